@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/analysis"
+	api "git.ebain.es/healthAndFitnessTracker/internal/api"
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/wcharczuk/go-chart"
+)
+
+const defaultChartWidth = 1024
+const defaultChartHeight = 400
+const minChartSize = 200
+const maxChartSize = 4000
+
+// chartBackground leaves room for the axis names, which go-chart otherwise draws at the very edge.
+var chartBackground = chart.Style{
+	Padding: chart.Box{Top: 20, Left: 20, Right: 20},
+}
+
+// errNotEnoughData is returned by chart builders when the series are too short to plot.
+var errNotEnoughData = errors.New("not enough data to draw the chart")
+
+// calorieChartStart skips the first week of smoothed calories, where the smoothing window is lopsided.
+const calorieChartStart = 7
+
+// chartPoints returns the dates and values of the days in s that have a value, starting from the day at index start.
+func chartPoints(dates []time.Time, s analysis.Series, start int) ([]time.Time, []float64) {
+	var xValues []time.Time
+	var yValues []float64
+	for i := start; i < len(s); i++ {
+		if s[i].Valid {
+			xValues = append(xValues, dates[i])
+			yValues = append(yValues, s[i].Float64)
+		}
+	}
+	return xValues, yValues
+}
+
+func weightChart(report *analysis.Report) (chart.Chart, error) {
+	weightDates, weights := chartPoints(report.Dates, report.Weight, 0)
+	if len(weights) < 2 {
+		return chart.Chart{}, errNotEnoughData
+	}
+
+	graph := chart.Chart{
+		Background: chartBackground,
+		XAxis: chart.XAxis{
+			Name:      "Date",
+			NameStyle: chart.StyleShow(),
+			Style:     chart.StyleShow(),
+		},
+		YAxis: chart.YAxis{
+			Name:      "Weight /kg",
+			NameStyle: chart.StyleShow(),
+			Style:     chart.StyleShow(),
+		},
+		YAxisSecondary: chart.YAxis{
+			Name:      "Calories /kcal",
+			NameStyle: chart.StyleShow(),
+			Style:     chart.StyleShow(),
+		},
+		Series: []chart.Series{
+			chart.TimeSeries{
+				Name:    "Smoothed Daily Weight",
+				XValues: weightDates,
+				YValues: weights,
+			},
+		},
+	}
+	if calorieDates, calories := chartPoints(report.Dates, report.Calories, calorieChartStart); len(calories) >= 2 {
+		graph.Series = append(graph.Series, chart.TimeSeries{
+			Name:    "Smoothed Daily Calories",
+			YAxis:   chart.YAxisSecondary,
+			XValues: calorieDates,
+			YValues: calories,
+		})
+	}
+	graph.Elements = []chart.Renderable{
+		chart.LegendThin(&graph),
+	}
+	return graph, nil
+}
+
+func tdeeChart(report *analysis.Report) (chart.Chart, error) {
+	tdeeDates, tdee := chartPoints(report.Dates, report.TDEE, 0)
+	if len(tdee) < 2 {
+		return chart.Chart{}, errNotEnoughData
+	}
+
+	graph := chart.Chart{
+		Background: chartBackground,
+		XAxis: chart.XAxis{
+			Name:      "Date",
+			NameStyle: chart.StyleShow(),
+			Style:     chart.StyleShow(),
+		},
+		YAxis: chart.YAxis{
+			Name:      "Calories /kcal",
+			NameStyle: chart.StyleShow(),
+			Style:     chart.StyleShow(),
+		},
+		YAxisSecondary: chart.YAxis{
+			Name:      "Weight /kg",
+			NameStyle: chart.StyleShow(),
+			Style:     chart.StyleShow(),
+		},
+		Series: []chart.Series{
+			chart.TimeSeries{
+				Name:    "TDEE",
+				XValues: tdeeDates,
+				YValues: tdee,
+			},
+		},
+	}
+	if weightDates, weights := chartPoints(report.Dates, report.Weight, 0); len(weights) >= 2 {
+		graph.Series = append(graph.Series, chart.TimeSeries{
+			Name:    "Weight /kg",
+			YAxis:   chart.YAxisSecondary,
+			XValues: weightDates,
+			YValues: weights,
+		})
+	}
+	graph.Elements = []chart.Renderable{
+		chart.LegendThin(&graph),
+	}
+	return graph, nil
+}
+
+func parseChartSize(value string, def int) (int, bool) {
+	if value == "" {
+		return def, true
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < minChartSize || size > maxChartSize {
+		return 0, false
+	}
+	return size, true
+}
+
+// renderChart serves a chart of the user's data. The optional from and to query parameters (YYYY-MM-DD)
+// limit the date range and width and height set the size in pixels.
+func renderChart(cfg *config.Config, store *database.Store, build func(*analysis.Report) (chart.Chart, error), provider chart.RendererProvider, contentType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var from, to time.Time
+		var err error
+
+		if value := c.Query("from"); value != "" {
+			if from, err = time.Parse(database.DateFormat, value); err != nil {
+				c.String(http.StatusBadRequest, "Invalid from date - expected YYYY-MM-DD")
+				return
+			}
+		}
+		if value := c.Query("to"); value != "" {
+			if to, err = time.Parse(database.DateFormat, value); err != nil {
+				c.String(http.StatusBadRequest, "Invalid to date - expected YYYY-MM-DD")
+				return
+			}
+		}
+		width, ok := parseChartSize(c.Query("width"), defaultChartWidth)
+		if !ok {
+			c.String(http.StatusBadRequest, "width must be between %d and %d", minChartSize, maxChartSize)
+			return
+		}
+		height, ok := parseChartSize(c.Query("height"), defaultChartHeight)
+		if !ok {
+			c.String(http.StatusBadRequest, "height must be between %d and %d", minChartSize, maxChartSize)
+			return
+		}
+
+		// Compute over the table's days before picking out the range so the chart agrees with the table.
+		var graph chart.Chart
+		report, err := analysis.ForUser(store, api.CurrentUser(c).ID, analysis.OptionsFromConfig(cfg), from, to)
+		if err == nil {
+			graph, err = build(report)
+		}
+		if errors.Is(err, errNotEnoughData) {
+			c.String(http.StatusUnprocessableEntity, errNotEnoughData.Error())
+			return
+		} else if err != nil {
+			log.Print(err)
+			c.String(http.StatusInternalServerError, "The chart could not be generated.")
+			return
+		}
+
+		graph.Width = width
+		graph.Height = height
+
+		var buffer bytes.Buffer
+		if err := graph.Render(provider, &buffer); err != nil {
+			log.Print(err)
+			c.String(http.StatusInternalServerError, "The chart could not be generated.")
+			return
+		}
+		c.Data(http.StatusOK, contentType, buffer.Bytes())
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/analysis"
+)
+
+func TestParseChartSize(t *testing.T) {
+	tests := []struct {
+		value string
+		size  int
+		ok    bool
+	}{
+		{"", 1024, true},
+		{"800", 800, true},
+		{"200", 200, true},
+		{"4000", 4000, true},
+		{"199", 0, false},
+		{"4001", 0, false},
+		{"wide", 0, false},
+	}
+	for _, test := range tests {
+		size, ok := parseChartSize(test.value, defaultChartWidth)
+		if size != test.size || ok != test.ok {
+			t.Errorf("parseChartSize(%q) returned %d, %v not %d, %v", test.value, size, ok, test.size, test.ok)
+		}
+	}
+}
+
+func TestChartsNeedEnoughData(t *testing.T) {
+	report := &analysis.Report{
+		Dates: []time.Time{
+			time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		Weight: analysis.Series{{Float64: 80, Valid: true}, {}},
+		TDEE:   analysis.Series{{}, {}},
+	}
+	if _, err := weightChart(report); err != errNotEnoughData {
+		t.Errorf("weightChart returned %v not %v", err, errNotEnoughData)
+	}
+	if _, err := tdeeChart(report); err != errNotEnoughData {
+		t.Errorf("tdeeChart returned %v not %v", err, errNotEnoughData)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/analysis"
+	api "git.ebain.es/healthAndFitnessTracker/internal/api"
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	"git.ebain.es/healthAndFitnessTracker/internal/export"
+	"git.ebain.es/healthAndFitnessTracker/internal/importer"
+	"golang.org/x/term"
+)
+
+// commands are the subcommands that can be given instead of starting the web server.
+var commands = map[string]func(args []string) error{
+	"user":   runUserCommand,
+	"token":  runTokenCommand,
+	"export": runExportCommand,
+	"import": runImportCommand,
+}
+
+func runUserCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: user add|passwd|list [flags]")
+	}
+
+	fs := flag.NewFlagSet("user "+args[0], flag.ExitOnError)
+	username := fs.String("username", "", "name the user logs in with")
+	timezone := fs.String("user-timezone", "", "IANA timezone for the user's days (default: server timezone)")
+
+	cfg, err := config.Load(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "add":
+		if *username == "" {
+			return errors.New("user add: -username is required")
+		}
+		if *timezone != "" {
+			if _, err := time.LoadLocation(*timezone); err != nil {
+				return fmt.Errorf("user add: %w", err)
+			}
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		user, err := store.CreateUser(*username, password, *timezone)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created user %s with id %d\n", user.Username, user.ID)
+	case "passwd":
+		if *username == "" {
+			return errors.New("user passwd: -username is required")
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if err := store.SetPassword(*username, password); errors.Is(err, database.ErrNotFound) {
+			return fmt.Errorf("user passwd: no user named %q", *username)
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("Updated password for %s\n", *username)
+	case "list":
+		users, err := store.ListUsers()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSERNAME\tTIMEZONE")
+		for _, user := range users {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", user.ID, user.Username, user.Timezone)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("user: unknown command %q", args[0])
+	}
+	return nil
+}
+
+func runTokenCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: token create|revoke|list [flags]")
+	}
+
+	fs := flag.NewFlagSet("token "+args[0], flag.ExitOnError)
+	username := fs.String("username", "", "user the token belongs to")
+	name := fs.String("name", "", "label to recognise the token by, e.g. the device using it")
+	scopeList := fs.String("scopes", database.ScopeRead, "comma separated scopes: read, write, delete")
+	id := fs.Int64("id", 0, "id of the token to revoke")
+
+	cfg, err := config.Load(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "create":
+		if *username == "" || *name == "" {
+			return errors.New("token create: -username and -name are required")
+		}
+		scopes, err := database.ParseScopes(*scopeList)
+		if err != nil {
+			return err
+		}
+		user, err := store.GetUserByName(*username)
+		if errors.Is(err, database.ErrNotFound) {
+			return fmt.Errorf("token create: no user named %q", *username)
+		} else if err != nil {
+			return err
+		}
+		plaintext, token, err := store.CreateToken(user.ID, *name, scopes)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Created token %d for %s with scopes %s. It will not be shown again:\n",
+			token.ID, user.Username, strings.Join(token.Scopes, ","))
+		fmt.Println(plaintext)
+	case "revoke":
+		if *id == 0 {
+			return errors.New("token revoke: -id is required")
+		}
+		if err := store.RevokeToken(*id); errors.Is(err, database.ErrNotFound) {
+			return fmt.Errorf("token revoke: no active token with id %d", *id)
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("Revoked token %d\n", *id)
+	case "list":
+		if *username == "" {
+			return errors.New("token list: -username is required")
+		}
+		user, err := store.GetUserByName(*username)
+		if errors.Is(err, database.ErrNotFound) {
+			return fmt.Errorf("token list: no user named %q", *username)
+		} else if err != nil {
+			return err
+		}
+		tokens, err := store.ListTokens(user.ID)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tLAST USED\tREVOKED")
+		for _, token := range tokens {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", token.ID, token.Name, strings.Join(token.Scopes, ","),
+				token.CreatedAt, token.LastUsed.String, token.RevokedAt.String)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("token: unknown command %q", args[0])
+	}
+	return nil
+}
+
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	username := fs.String("username", "", "user whose days are exported")
+	dataset := fs.String("data", "days", "data to export: days for the logged values or analytics for the computed series")
+	formatName := fs.String("format", export.CSV.Extension, "file format: csv or xlsx")
+	output := fs.String("o", "", "file to write (default: standard output)")
+	fromDate := fs.String("from", "", "first day to export (YYYY-MM-DD)")
+	toDate := fs.String("to", "", "last day to export (YYYY-MM-DD)")
+
+	cfg, err := config.Load(fs, args)
+	if err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("export: -username is required")
+	}
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	var from, to time.Time
+	if *fromDate != "" {
+		if from, err = time.Parse(database.DateFormat, *fromDate); err != nil {
+			return fmt.Errorf("export: invalid -from date: %w", err)
+		}
+	}
+	if *toDate != "" {
+		if to, err = time.Parse(database.DateFormat, *toDate); err != nil {
+			return fmt.Errorf("export: invalid -to date: %w", err)
+		}
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	user, err := store.GetUserByName(*username)
+	if errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("export: no user named %q", *username)
+	} else if err != nil {
+		return err
+	}
+
+	var table *export.Table
+	switch *dataset {
+	case "days":
+		records, err := store.QueryDays(user.ID, database.DayQuery{From: from, To: to})
+		if err != nil {
+			return err
+		}
+		table = export.Days(records, api.ExportOptions(cfg))
+	case "analytics":
+		// Compute over the same days as the table and API so the values match theirs.
+		report, err := analysis.ForUser(store, user.ID, analysis.OptionsFromConfig(cfg), from, to)
+		if err != nil {
+			return err
+		}
+		table = export.Report(report, api.ExportOptions(cfg))
+	default:
+		return fmt.Errorf("export: unknown data %q, expected days or analytics", *dataset)
+	}
+
+	if *output == "" {
+		return format.Write(os.Stdout, table)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := format.Write(f, table); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func runImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	username := fs.String("username", "", "user the days are imported for")
+	input := fs.String("i", "", "file to import (default: standard input)")
+	format := fs.String("format", "csv", "file format: csv, apple-health, google-fit, fitbit or garmin")
+	weightUnit := fs.String("weight-unit", string(importer.Kilograms), "unit of weights in fitbit files: kg or lb")
+	dateColumn := fs.String("date-column", importer.DefaultMapping.Date, "header of the date column")
+	weightColumn := fs.String("weight-column", importer.DefaultMapping.Weight, "header of the weight column, empty to skip weights")
+	calorieColumn := fs.String("calories-column", importer.DefaultMapping.Calories, "header of the calories column, empty to skip calories")
+	delimiter := fs.String("delimiter", ",", "field delimiter")
+	conflict := fs.String("conflict", string(database.ConflictSkip), "what to do with days that already exist: skip, overwrite or merge")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing anything")
+	var dateFormats stringList
+	fs.Var(&dateFormats, "import-date-format", "Go layout of the dates in the file; may be repeated (default: 2006-01-02 and RFC 3339)")
+
+	cfg, err := config.Load(fs, args)
+	if err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("import: -username is required")
+	}
+	mode, err := database.ParseConflictMode(*conflict)
+	if err != nil {
+		return err
+	}
+	comma, size := utf8.DecodeRuneInString(*delimiter)
+	if size == 0 || size != len(*delimiter) {
+		return errors.New("import: -delimiter must be a single character")
+	}
+	parser, ok := importer.Parsers[*format]
+	if !ok && *format != "csv" {
+		return fmt.Errorf("import: unknown format %q", *format)
+	}
+	unit, err := importer.ParseUnit(*weightUnit)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	user, err := store.GetUserByName(*username)
+	if errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("import: no user named %q", *username)
+	} else if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if *input != "" {
+		if in, err = os.Open(*input); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	loc := api.UserLocation(user, cfg.Location())
+	var rows []importer.Row
+	var invalid []importer.RowError
+	if parser != nil {
+		rows, invalid, err = parser(in, importer.Options{Location: loc, WeightUnit: unit})
+	} else {
+		rows, invalid, err = importer.ParseCSV(in, importer.CSVOptions{
+			Mapping:     importer.Mapping{Date: *dateColumn, Weight: *weightColumn, Calories: *calorieColumn},
+			DateFormats: dateFormats,
+			Location:    loc,
+			Comma:       comma,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	report, err := api.ImportRows(store, user.ID, rows, invalid, loc, mode, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, rowErr := range report.Invalid {
+		fmt.Fprintln(os.Stderr, rowErr)
+	}
+	for _, conflict := range report.Conflicts {
+		if conflict.Line != 0 {
+			fmt.Fprintf(os.Stderr, "line %d: ", conflict.Line)
+		}
+		fmt.Fprintf(os.Stderr, "%s already exists (%s)\n", conflict.Date, conflict.Resolution)
+	}
+	if !report.Written && len(report.Invalid) > 0 {
+		return fmt.Errorf("import: %d invalid rows, nothing was imported", len(report.Invalid))
+	}
+
+	verb := "Imported"
+	if !report.Written {
+		verb = "Would import"
+	}
+	fmt.Printf("%s %d new days, updated %d and skipped %d\n", verb, report.Inserted, report.Updated, report.Skipped)
+	return nil
+}
+
+// readPassword reads a password from the first line of standard input.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	var password string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		// Read without echoing the password back to the terminal.
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		password = string(b)
+	} else {
+		// Piped input, such as a script creating users, is read a line at a time.
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if len(password) < 8 {
+		return "", errors.New("the password must be at least 8 characters")
+	}
+	return password, nil
+}
@@ -0,0 +1,364 @@
+// Package analysis derives the smoothed per-day series shown by the table, charts and API from a user's day records.
+package analysis
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	regression "git.ebain.es/healthAndFitnessTracker/internal/regression"
+)
+
+// Options controls how the series in a Report are smoothed and averaged.
+type Options struct {
+	WeightBandwidth      float64
+	CalorieBandwidth     float64
+	WeightDeltaBandwidth float64
+	// TDEEWindow is the number of days averaged for each TDEE estimate.
+	TDEEWindow int
+	// WeightRobustIterations is the number of robust LOESS iterations used when smoothing weights and weight
+	// changes, so a single outlying weigh-in does not drag the trend.
+	WeightRobustIterations int
+	// LOESSDegree is the degree of the local LOESS fits, 1 for straight lines or 2 for quadratics. Zero means 1.
+	LOESSDegree int
+	// WeightSmoother, CalorieSmoother and WeightDeltaSmoother replace the LOESS smoothing of their series when set.
+	WeightSmoother      regression.Smoother
+	CalorieSmoother     regression.Smoother
+	WeightDeltaSmoother regression.Smoother
+}
+
+// OptionsFromConfig returns the analysis options set in cfg.
+func OptionsFromConfig(cfg *config.Config) Options {
+	return Options{
+		WeightBandwidth:        cfg.WeightBandwidth,
+		CalorieBandwidth:       cfg.CalorieBandwidth,
+		WeightDeltaBandwidth:   cfg.WeightDeltaBandwidth,
+		TDEEWindow:             cfg.TDEEWindow,
+		WeightRobustIterations: cfg.WeightRobustIterations,
+		LOESSDegree:            cfg.LOESSDegree,
+		WeightSmoother:         smootherFromConfig(cfg, cfg.WeightSmoother),
+		CalorieSmoother:        smootherFromConfig(cfg, cfg.CalorieSmoother),
+		WeightDeltaSmoother:    smootherFromConfig(cfg, cfg.WeightDeltaSmoother),
+	}
+}
+
+// smootherFromConfig returns the smoother of the named kind, or nil for LOESS, which is set up from the series'
+// bandwidth.
+func smootherFromConfig(cfg *config.Config, kind string) regression.Smoother {
+	switch regression.SmootherKind(kind) {
+	case regression.KindEMA:
+		return regression.EMA{Smoothing: cfg.EMASmoothing}
+	case regression.KindKalman:
+		return regression.Kalman{ProcessVariance: cfg.KalmanNoiseRatio, MeasurementVariance: 1}
+	}
+	return nil
+}
+
+// orLOESS returns smoother, or LOESS over the given bandwidth when it is nil.
+func orLOESS(smoother regression.Smoother, bandwidth float64, options ...regression.Option) regression.Smoother {
+	if smoother != nil {
+		return smoother
+	}
+	return regression.LOESS{Bandwidth: bandwidth, Options: options}
+}
+
+// TableDays is how many of a user's most recent days the table, charts and API analyse. The smoothing
+// depends on the span of days analysed, so they all compute over the same days to agree.
+const TableDays = 1000
+
+// ForUser computes the report over the user's most recent TableDays days and returns the part between from
+// and to, either of which may be zero to leave that end open. The values for a day are the table's and do
+// not change with the range requested. Older days are not included.
+func ForUser(store *database.Store, userID int64, opts Options, from time.Time, to time.Time) (*Report, error) {
+	records, err := store.ListDays(userID, TableDays)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := Compute(records, opts)
+	if err != nil {
+		return nil, err
+	}
+	report = report.Since(from)
+	if !to.IsZero() {
+		report = report.Until(to)
+	}
+	return report, nil
+}
+
+// Series holds one value per day of a Report. Days without enough data to estimate a value are NULL.
+type Series []sql.NullFloat64
+
+// Report is the computed analysis of a run of day records. Every series has one entry per entry of Dates.
+type Report struct {
+	Records []database.DayRecord
+	Dates   []time.Time
+	// Weight and Calories are the smoothed daily weight and calories.
+	Weight   Series
+	Calories Series
+	// WeightChange is the smoothed change in weight from the previous day.
+	WeightChange Series
+	// WeightChange7 and WeightChange28 are the changes in smoothed weight over the last 7 and 28 days.
+	WeightChange7  Series
+	WeightChange28 Series
+	// CalorieChange7 is the change in smoothed calories over the last 7 days.
+	CalorieChange7 Series
+	// TDEE is the estimated total daily energy expenditure, averaged over the TDEE window ending TDEEWindow
+	// days before each day.
+	TDEE Series
+}
+
+// Len returns the number of days in the report.
+func (r *Report) Len() int {
+	return len(r.Dates)
+}
+
+// Since returns the part of the report from date onwards. The values are not recomputed, so days
+// before date still contribute to the smoothing.
+func (r *Report) Since(date time.Time) *Report {
+	start := sort.Search(len(r.Dates), func(i int) bool {
+		return !r.Dates[i].Before(date)
+	})
+	return &Report{
+		Records:        r.Records[start:],
+		Dates:          r.Dates[start:],
+		Weight:         r.Weight[start:],
+		Calories:       r.Calories[start:],
+		WeightChange:   r.WeightChange[start:],
+		WeightChange7:  r.WeightChange7[start:],
+		WeightChange28: r.WeightChange28[start:],
+		CalorieChange7: r.CalorieChange7[start:],
+		TDEE:           r.TDEE[start:],
+	}
+}
+
+// Until returns the part of the report up to and including date.
+func (r *Report) Until(date time.Time) *Report {
+	end := sort.Search(len(r.Dates), func(i int) bool {
+		return r.Dates[i].After(date)
+	})
+	return &Report{
+		Records:        r.Records[:end],
+		Dates:          r.Dates[:end],
+		Weight:         r.Weight[:end],
+		Calories:       r.Calories[:end],
+		WeightChange:   r.WeightChange[:end],
+		WeightChange7:  r.WeightChange7[:end],
+		WeightChange28: r.WeightChange28[:end],
+		CalorieChange7: r.CalorieChange7[:end],
+		TDEE:           r.TDEE[:end],
+	}
+}
+
+// Compute analyses records, which must be in ascending date order.
+func Compute(records []database.DayRecord, opts Options) (*Report, error) {
+	if opts.TDEEWindow < 1 {
+		return nil, fmt.Errorf("analysis: the TDEE window must be at least 1 day")
+	}
+
+	var dates = make([]time.Time, 0, len(records))
+	var weightDates = make([]time.Time, 0, len(records))
+	var calorieDates = make([]time.Time, 0, len(records))
+	var weights, calories []float64
+
+	// Only smooth over the days where the weight/calories were logged.
+	for _, record := range records {
+		dates = append(dates, record.Time)
+		if record.Weight.Valid {
+			weightDates = append(weightDates, record.Time)
+			weights = append(weights, record.Weight.Float64)
+		}
+		if record.Calories.Valid {
+			calorieDates = append(calorieDates, record.Time)
+			calories = append(calories, record.Calories.Float64)
+		}
+	}
+
+	var loessOptions []regression.Option
+	if opts.LOESSDegree != 0 {
+		loessOptions = append(loessOptions, regression.Degree(opts.LOESSDegree))
+	}
+	weightLOESSOptions := append([]regression.Option{regression.RobustIterations(opts.WeightRobustIterations)}, loessOptions...)
+
+	// Smooth onto every calendar day from the first record to the last, so that the 7 and 28 day changes and
+	// the TDEE window count calendar days rather than logged days. Each record's values are then picked out.
+	calendar, calendarIndex := calendarDays(dates)
+
+	smoothedWeights, err := smoothTimeSeries(calendar, weightDates, weights, orLOESS(opts.WeightSmoother, opts.WeightBandwidth, weightLOESSOptions...))
+	if err != nil {
+		return nil, fmt.Errorf("analysis: smoothing weights: %w", err)
+	}
+
+	smoothedCalories, err := smoothTimeSeries(calendar, calorieDates, calories, orLOESS(opts.CalorieSmoother, opts.CalorieBandwidth, loessOptions...))
+	if err != nil {
+		return nil, fmt.Errorf("analysis: smoothing calories: %w", err)
+	}
+
+	// Calculate weight change per day and smooth.
+	dayWeightDelta := calculateDailyChange(weightDates, weights)
+	smoothedDayWeightDelta, err := smoothTimeSeries(calendar, weightDates, dayWeightDelta, orLOESS(opts.WeightDeltaSmoother, opts.WeightDeltaBandwidth, weightLOESSOptions...))
+	if err != nil {
+		return nil, fmt.Errorf("analysis: smoothing weight change: %w", err)
+	}
+
+	// Each TDEE estimate covers the window of calendar days ending TDEEWindow days before the day it is reported
+	// against, as the table has always shown it.
+	calorieSlidingAverage := slidingAvgs(smoothedCalories, opts.TDEEWindow)
+	weightDeltaSlidingAverage := slidingAvgs(smoothedDayWeightDelta, opts.TDEEWindow)
+	tdeeLag := 2*opts.TDEEWindow - 1
+	tdee := make([]float64, len(calendar))
+	for i := range tdee {
+		if i < tdeeLag {
+			tdee[i] = math.NaN()
+			continue
+		}
+		tdee[i] = calculateTDEE(calorieSlidingAverage[i-tdeeLag], weightDeltaSlidingAverage[i-tdeeLag])
+	}
+
+	return &Report{
+		Records:        records,
+		Dates:          dates,
+		Weight:         newSeries(pickDays(smoothedWeights, calendarIndex)),
+		Calories:       newSeries(pickDays(smoothedCalories, calendarIndex)),
+		WeightChange:   newSeries(pickDays(smoothedDayWeightDelta, calendarIndex)),
+		WeightChange7:  newSeries(pickDays(calculateDayDifferences(smoothedWeights, 7, math.NaN()), calendarIndex)),
+		WeightChange28: newSeries(pickDays(calculateDayDifferences(smoothedWeights, 28, math.NaN()), calendarIndex)),
+		CalorieChange7: newSeries(pickDays(calculateDayDifferences(smoothedCalories, 7, math.NaN()), calendarIndex)),
+		TDEE:           newSeries(pickDays(tdee, calendarIndex)),
+	}, nil
+}
+
+// calendarDays returns every calendar day from the first of dates to the last, and the position of each of
+// dates among them.
+func calendarDays(dates []time.Time) ([]time.Time, []int) {
+	if len(dates) == 0 {
+		return nil, nil
+	}
+
+	index := make([]int, len(dates))
+	for i, date := range dates {
+		index[i] = int(math.Round(dayOffset(date, dates[0])))
+	}
+	calendar := make([]time.Time, index[len(index)-1]+1)
+	for day := range calendar {
+		calendar[day] = dates[0].AddDate(0, 0, day)
+	}
+	return calendar, index
+}
+
+// pickDays returns the values at each position of index.
+func pickDays(values []float64, index []int) []float64 {
+	picked := make([]float64, len(index))
+	for i, day := range index {
+		picked[i] = values[day]
+	}
+	return picked
+}
+
+// newSeries converts values to a Series, treating NaN as missing.
+func newSeries(values []float64) Series {
+	s := make(Series, len(values))
+	for i, value := range values {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			s[i] = sql.NullFloat64{Float64: value, Valid: true}
+		}
+	}
+	return s
+}
+
+func slidingAvgs(dayValues []float64, width int) []float64 {
+	var weekAvg float64
+	var avgSlice = make([]float64, 0)
+
+	for i := width - 1; i < len(dayValues); i++ {
+		weekAvg = 0
+		for _, dayValue := range dayValues[i-(width-1) : i+1] {
+			weekAvg += dayValue
+		}
+
+		weekAvg = weekAvg / float64(width)
+
+		avgSlice = append(avgSlice, weekAvg)
+	}
+	return avgSlice
+}
+
+// calculateDayDifferences returns the change in each value from the value days earlier.
+// The first days values have nothing to compare against and are set to pad.
+func calculateDayDifferences(values []float64, days int, pad float64) []float64 {
+	var diffSlice = make([]float64, 0, len(values))
+
+	for i := 0; i < len(values); i++ {
+		if i-days < 0 {
+			diffSlice = append(diffSlice, pad)
+		} else {
+			diffSlice = append(diffSlice, values[i]-values[i-days])
+		}
+	}
+	return diffSlice
+}
+
+// calculateDailyChange returns the change per day in each value since the previous one, spreading the
+// change over the days between them when days were missed. The first value has no change.
+func calculateDailyChange(dates []time.Time, values []float64) []float64 {
+	var changes = make([]float64, 0, len(values))
+
+	for i := 0; i < len(values); i++ {
+		if i == 0 {
+			changes = append(changes, 0)
+		} else {
+			changes = append(changes, (values[i]-values[i-1])/dayOffset(dates[i], dates[i-1]))
+		}
+	}
+	return changes
+}
+
+func calculateTDEE(avgDayCalories float64, avgDayWeightDiff float64) float64 {
+	// Approx 3500kcal = 450g fat
+	fatCaloriesDiff := (avgDayWeightDiff * 3500) / 0.450
+	tdee := avgDayCalories - fatCaloriesDiff
+	return tdee
+}
+
+// dayOffset returns the number of days from epoch to t, so that series logged on different days share
+// one x axis and gaps in logging count as elapsed time.
+func dayOffset(t time.Time, epoch time.Time) float64 {
+	return t.Sub(epoch).Hours() / 24
+}
+
+// smoothTimeSeries estimates yPoints, logged on dates, at each of datesToEstimate using smoother.
+// With nothing logged every estimate is NaN.
+func smoothTimeSeries(datesToEstimate []time.Time, dates []time.Time, yPoints []float64, smoother regression.Smoother) ([]float64, error) {
+	if len(dates) == 0 || len(datesToEstimate) == 0 {
+		estimates := make([]float64, len(datesToEstimate))
+		for i := range estimates {
+			estimates[i] = math.NaN()
+		}
+		return estimates, nil
+	}
+
+	epoch := datesToEstimate[0]
+	var xPointsToEstimate = make([]float64, 0, len(datesToEstimate))
+	for _, date := range datesToEstimate {
+		xPointsToEstimate = append(xPointsToEstimate, dayOffset(date, epoch))
+	}
+
+	var coordinates = make([]regression.Coord, 0, len(dates))
+	for i, date := range dates {
+		coordinates = append(coordinates, regression.Coord{
+			X: dayOffset(date, epoch),
+			Y: yPoints[i],
+		})
+	}
+
+	smoothedCoords, err := smoother.Smooth(xPointsToEstimate, coordinates)
+	if err != nil {
+		return nil, err
+	}
+	_, estimates := regression.CoordsToArrays(smoothedCoords)
+	return estimates, nil
+}
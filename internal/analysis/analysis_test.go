@@ -0,0 +1,268 @@
+package analysis
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	regression "git.ebain.es/healthAndFitnessTracker/internal/regression"
+)
+
+func TestSlidingAvgs(t *testing.T) {
+	var values = []float64{82, 82, 82, 82, 82, 82, 82}
+	avgs := slidingAvgs(values, 7)
+	if avgs[0] != 82 {
+		t.Error()
+	}
+
+	values = []float64{82, 82, 82, 82, 82, 82, 82, 81, 81, 83}
+	correct := []float64{82, 81.9, 81.7, 81.9}
+	avgs = slidingAvgs(values, 7)
+	for i := 0; i < len(avgs); i++ {
+		if math.Round(avgs[i]*10)/10 != correct[i] {
+			t.Error()
+		}
+	}
+
+}
+
+func testRecords(days int, withCalories bool) []database.DayRecord {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := make([]database.DayRecord, 0, days)
+	for i := 0; i < days; i++ {
+		record := database.DayRecord{
+			ID:     int64(i + 1),
+			Time:   start.AddDate(0, 0, i),
+			Weight: sql.NullFloat64{Float64: 80 - float64(i)*0.1, Valid: true},
+		}
+		if withCalories {
+			record.Calories = sql.NullFloat64{Float64: 2500, Valid: true}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+var testOptions = Options{
+	WeightBandwidth:      0.2,
+	CalorieBandwidth:     0.2,
+	WeightDeltaBandwidth: 0.4,
+	TDEEWindow:           14,
+}
+
+func TestCompute(t *testing.T) {
+	report, err := Compute(testRecords(60, true), testOptions)
+	if err != nil {
+		t.Fatalf("Compute returned %v", err)
+	}
+	if report.Len() != 60 {
+		t.Fatalf("Compute returned %d days not %d", report.Len(), 60)
+	}
+
+	series := map[string]Series{
+		"Weight": report.Weight, "Calories": report.Calories, "WeightChange": report.WeightChange,
+		"WeightChange7": report.WeightChange7, "WeightChange28": report.WeightChange28,
+		"CalorieChange7": report.CalorieChange7, "TDEE": report.TDEE,
+	}
+	firstValues := map[string]int{
+		"Weight": 0, "Calories": 0, "WeightChange": 0,
+		"WeightChange7": 7, "WeightChange28": 28, "CalorieChange7": 7, "TDEE": 27,
+	}
+	for name, s := range series {
+		if len(s) != 60 {
+			t.Errorf("%s has %d values not %d", name, len(s), 60)
+			continue
+		}
+		for i, value := range s {
+			if value.Valid != (i >= firstValues[name]) {
+				t.Errorf("%s[%d].Valid is %v", name, i, value.Valid)
+			}
+		}
+	}
+
+	// A steady loss of 0.1kg a day eating 2500kcal.
+	if weight := report.Weight[30].Float64; math.Abs(weight-77) > 0.01 {
+		t.Errorf("Weight[30] is %v not %v", weight, 77.0)
+	}
+	if change := report.WeightChange7[30].Float64; math.Abs(change+0.7) > 0.01 {
+		t.Errorf("WeightChange7[30] is %v not %v", change, -0.7)
+	}
+	wantTDEE := calculateTDEE(2500, -0.1)
+	if tdee := report.TDEE[50].Float64; math.Abs(tdee-wantTDEE) > 1 {
+		t.Errorf("TDEE[50] is %v not %v", tdee, wantTDEE)
+	}
+}
+
+func TestForUser(t *testing.T) {
+	store, err := database.Open(database.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	user, err := store.CreateUser("alice", "correct horse", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ImportDays(user.ID, testRecords(60, true), database.ConflictSkip, false); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := ForUser(store, user.ID, testOptions, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Len() != 60 {
+		t.Fatalf("ForUser returned %d days not %d", all.Len(), 60)
+	}
+
+	// A range is picked out of the whole report rather than smoothed on its own.
+	from, to := all.Dates[40], all.Dates[44]
+	part, err := ForUser(store, user.ID, testOptions, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.Len() != 5 || !part.Dates[0].Equal(from) || !part.Dates[4].Equal(to) {
+		t.Fatalf("ForUser returned the days %v", part.Dates)
+	}
+	for i := range part.Dates {
+		if part.Weight[i] != all.Weight[40+i] || part.TDEE[i] != all.TDEE[40+i] {
+			t.Errorf("ForUser returned %v and %v for day %d not %v and %v",
+				part.Weight[i], part.TDEE[i], 40+i, all.Weight[40+i], all.TDEE[40+i])
+		}
+	}
+}
+
+func TestComputeMissingValues(t *testing.T) {
+	report, err := Compute(nil, testOptions)
+	if err != nil {
+		t.Errorf("Compute with no records returned %v", err)
+	} else if report.Len() != 0 {
+		t.Errorf("Compute with no records returned %d days", report.Len())
+	}
+
+	report, err = Compute(testRecords(30, false), testOptions)
+	if err != nil {
+		t.Fatalf("Compute without calories returned %v", err)
+	}
+	for i := range report.Dates {
+		if report.Calories[i].Valid || report.TDEE[i].Valid {
+			t.Errorf("day %d has calories or TDEE without any calories logged", i)
+		}
+		if !report.Weight[i].Valid {
+			t.Errorf("day %d has no smoothed weight", i)
+		}
+	}
+
+	if _, err := Compute(testRecords(10, true), Options{WeightBandwidth: 0.2, CalorieBandwidth: 0.2, WeightDeltaBandwidth: 0.4}); err == nil {
+		t.Error("Compute accepted a TDEE window of 0")
+	}
+}
+
+func TestComputeUsesCalendarDays(t *testing.T) {
+	// A linear loss of 0.1kg a day with a five day gap in logging, and calories rising by 10kcal a day
+	// but only logged every other day.
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var records []database.DayRecord
+	for day := 0; day < 74; day++ {
+		if day >= 30 && day < 35 {
+			continue
+		}
+		record := database.DayRecord{
+			Time:   start.AddDate(0, 0, day),
+			Weight: sql.NullFloat64{Float64: 80 - float64(day)*0.1, Valid: true},
+		}
+		if day%2 == 0 {
+			record.Calories = sql.NullFloat64{Float64: 2000 + float64(day)*10, Valid: true}
+		}
+		records = append(records, record)
+	}
+
+	report, err := Compute(records, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, date := range report.Dates {
+		day := float64(date.Sub(start).Hours() / 24)
+		if weight := report.Weight[i]; !weight.Valid || math.Abs(weight.Float64-(80-day*0.1)) > 0.01 {
+			t.Errorf("Weight on day %v is %v not %v", day, weight, 80-day*0.1)
+		}
+		if calories := report.Calories[i]; !calories.Valid || math.Abs(calories.Float64-(2000+day*10)) > 0.5 {
+			t.Errorf("Calories on day %v is %v not %v", day, calories, 2000+day*10)
+		}
+		// The first day has no change to smooth in, so skip the days it still drags towards zero.
+		if change := report.WeightChange[i]; day >= 14 && (!change.Valid || math.Abs(change.Float64+0.1) > 0.01) {
+			t.Errorf("WeightChange on day %v is %v not %v", day, change, -0.1)
+		}
+		// The change over 7 calendar days, which after the gap reaches back to days that were not logged.
+		if change := report.WeightChange7[i]; day >= 7 && (!change.Valid || math.Abs(change.Float64+0.7) > 0.01) {
+			t.Errorf("WeightChange7 on day %v is %v not %v", day, change, -0.7)
+		}
+		// TDEE averages calories over the 14 calendar days ending 14 days earlier, again reaching into the gap,
+		// once the window is clear of the first day's missing change.
+		wantTDEE := calculateTDEE(2000+(day-20.5)*10, -0.1)
+		if tdee := report.TDEE[i]; day >= 41 && (!tdee.Valid || math.Abs(tdee.Float64-wantTDEE) > 1) {
+			t.Errorf("TDEE on day %v is %v not %v", day, tdee, wantTDEE)
+		}
+	}
+}
+
+func TestComputeRobustWeight(t *testing.T) {
+	// Day to day noise of 0.2kg with a 2kg spike in the middle.
+	records := testRecords(60, true)
+	for i := range records {
+		records[i].Weight.Float64 += 0.2 * math.Pow(-1, float64(i))
+	}
+	records[30].Weight.Float64 += 2
+
+	opts := testOptions
+	opts.WeightRobustIterations = 3
+	report, err := Compute(records, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weight, trend := report.Weight[30].Float64, 80-30*0.1; math.Abs(weight-trend) > 0.1 {
+		t.Errorf("Weight on the spike is %v not %v", weight, trend)
+	}
+}
+
+func TestComputeCausalSmoother(t *testing.T) {
+	opts := testOptions
+	opts.WeightSmoother = regression.EMA{Smoothing: 0.1}
+
+	before, err := Compute(testRecords(30, true), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := testRecords(31, true)
+	records[30].Weight.Float64 += 5
+	after, err := Compute(records, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < before.Len(); i++ {
+		if before.Weight[i] != after.Weight[i] {
+			t.Errorf("Weight on day %v changed from %v to %v when a later day was added", i, before.Weight[i], after.Weight[i])
+		}
+	}
+}
+
+func TestComputeQuadraticLOESS(t *testing.T) {
+	// A cut ending on day 30 followed by a bulk.
+	records := testRecords(61, true)
+	for i := range records {
+		records[i].Weight.Float64 = 75 + 0.004*float64((i-30)*(i-30))
+	}
+
+	opts := testOptions
+	opts.LOESSDegree = 2
+	report, err := Compute(records, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weight := report.Weight[30].Float64; math.Abs(weight-75) > 1e-6 {
+		t.Errorf("Weight at the low is %v not %v", weight, 75)
+	}
+}
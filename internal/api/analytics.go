@@ -0,0 +1,97 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/analysis"
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// analyticsDayResponse is the JSON representation of one day of an analysis report, matching the
+// columns of the /table page. Values that cannot be estimated for the day are serialised as null.
+type analyticsDayResponse struct {
+	ID             int64    `json:"id"`
+	Date           string   `json:"date"`
+	Time           int64    `json:"time"`
+	Weight         *float64 `json:"weight"`
+	Calories       *float64 `json:"calories"`
+	WeightChange   *float64 `json:"weight_change"`
+	WeightChange7  *float64 `json:"weight_change_7"`
+	WeightChange28 *float64 `json:"weight_change_28"`
+	CalorieChange7 *float64 `json:"calorie_change_7"`
+	TDEE           *float64 `json:"tdee"`
+}
+
+func nullableFloat(value sql.NullFloat64) *float64 {
+	if !value.Valid {
+		return nil
+	}
+	v := value.Float64
+	return &v
+}
+
+func newAnalyticsDayResponse(report *analysis.Report, i int) analyticsDayResponse {
+	return analyticsDayResponse{
+		ID:             report.Records[i].ID,
+		Date:           report.Dates[i].Format(database.DateFormat),
+		Time:           report.Dates[i].Unix(),
+		Weight:         nullableFloat(report.Weight[i]),
+		Calories:       nullableFloat(report.Calories[i]),
+		WeightChange:   nullableFloat(report.WeightChange[i]),
+		WeightChange7:  nullableFloat(report.WeightChange7[i]),
+		WeightChange28: nullableFloat(report.WeightChange28[i]),
+		CalorieChange7: nullableFloat(report.CalorieChange7[i]),
+		TDEE:           nullableFloat(report.TDEE[i]),
+	}
+}
+
+// parseRange reads the optional from and to query parameters, responding with an error when either is
+// invalid. Unset dates are zero.
+func (h *Handler) parseRange(c *gin.Context) (from time.Time, to time.Time, ok bool) {
+	var err error
+	if fromParam := c.Query("from"); fromParam != "" {
+		if from, err = parseDateParam(fromParam, h.location(c, nil)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid from date"})
+			return from, to, false
+		}
+	}
+	if toParam := c.Query("to"); toParam != "" {
+		if to, err = parseDateParam(toParam, h.location(c, nil)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid to date"})
+			return from, to, false
+		}
+	}
+	return from, to, true
+}
+
+// report returns the analysis of the user's days between from and to, computed as analysis.ForUser does.
+func (h *Handler) report(userID int64, from time.Time, to time.Time) (*analysis.Report, error) {
+	return analysis.ForUser(h.store, userID, analysis.OptionsFromConfig(h.cfg), from, to)
+}
+
+// Analytics returns the smoothed series shown on the /table page for the days between the optional
+// from and to dates.
+func (h *Handler) Analytics(c *gin.Context) {
+	from, to, ok := h.parseRange(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.report(CurrentUser(c).ID, from, to)
+	if err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "Analysis failed"})
+		return
+	}
+
+	data := make([]analyticsDayResponse, 0, report.Len())
+	for i := range report.Dates {
+		data = append(data, newAnalyticsDayResponse(report, i))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": data})
+}
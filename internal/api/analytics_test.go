@@ -0,0 +1,135 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/analysis"
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+)
+
+func TestAnalytics(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	user, err := store.GetUserByName(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 40; i++ {
+		record := database.DayRecord{
+			Time:     start.AddDate(0, 0, i),
+			Weight:   sql.NullFloat64{Float64: 80 - float64(i)*0.1, Valid: true},
+			Calories: sql.NullFloat64{Float64: 2500, Valid: true},
+		}
+		if _, err := store.CreateDay(user.ID, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, response := doRequest(t, r, http.MethodGet, "/api/analytics", "")
+	if status != http.StatusOK {
+		t.Fatalf("GET /api/analytics returned %d: %s", status, response.Error)
+	}
+	var days []analyticsDayResponse
+	if err := json.Unmarshal(response.Data, &days); err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 40 {
+		t.Fatalf("GET /api/analytics returned %d days not %d", len(days), 40)
+	}
+	if days[0].Date != "2021-01-01" || days[0].Weight == nil {
+		t.Errorf("GET /api/analytics returned %+v for the first day", days[0])
+	}
+	if days[0].WeightChange7 != nil || days[0].TDEE != nil {
+		t.Errorf("GET /api/analytics padded the first day's changes and TDEE instead of returning null")
+	}
+	if days[39].WeightChange28 == nil || days[39].TDEE == nil {
+		t.Errorf("GET /api/analytics returned null changes or TDEE for the last day")
+	}
+
+	status, response = doRequest(t, r, http.MethodGet, "/api/analytics?from=2021-02-01&to=2021-02-05", "")
+	if status != http.StatusOK {
+		t.Fatalf("GET /api/analytics with a range returned %d: %s", status, response.Error)
+	}
+	var rangeDays []analyticsDayResponse
+	if err := json.Unmarshal(response.Data, &rangeDays); err != nil {
+		t.Fatal(err)
+	}
+	if len(rangeDays) != 5 || rangeDays[0].Date != "2021-02-01" {
+		t.Fatalf("GET /api/analytics with a range returned %+v", rangeDays)
+	}
+	// Days before from are still used to smooth the values returned.
+	if rangeDays[0].TDEE == nil {
+		t.Errorf("GET /api/analytics with a range returned null TDEE for %s", rangeDays[0].Date)
+	}
+
+	status, _ = doRequest(t, r, http.MethodGet, "/api/analytics?from=yesterday", "")
+	if status != http.StatusBadRequest {
+		t.Errorf("GET /api/analytics with an invalid date returned %d not %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestAnalyticsMatchesTable(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	user, err := store.GetUserByName(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More days than the table shows, with enough curve that the smoothing depends on the days analysed.
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	var records []database.DayRecord
+	for i := 0; i < analysis.TableDays+20; i++ {
+		records = append(records, database.DayRecord{
+			Time:     start.AddDate(0, 0, i),
+			Weight:   sql.NullFloat64{Float64: 80 + 3*math.Sin(float64(i)/40), Valid: true},
+			Calories: sql.NullFloat64{Float64: 2500 + 200*math.Cos(float64(i)/25), Valid: true},
+		})
+	}
+	if _, err := store.ImportDays(user.ID, records, database.ConflictSkip, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tableRecords, err := store.ListDays(user.ID, analysis.TableDays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := analysis.Compute(tableRecords, analysis.OptionsFromConfig(config.Default()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := table.Len() - 10
+	date := table.Dates[i].Format(database.DateFormat)
+	status, response := doRequest(t, r, http.MethodGet, "/api/analytics?from="+date+"&to="+date, "")
+	if status != http.StatusOK {
+		t.Fatalf("GET /api/analytics returned %d: %s", status, response.Error)
+	}
+	var days []analyticsDayResponse
+	if err := json.Unmarshal(response.Data, &days); err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 {
+		t.Fatalf("GET /api/analytics returned %d days not 1", len(days))
+	}
+
+	want := newAnalyticsDayResponse(table, i)
+	got := days[0]
+	pairs := map[string][2]*float64{
+		"weight": {got.Weight, want.Weight}, "weight_change_7": {got.WeightChange7, want.WeightChange7},
+		"tdee": {got.TDEE, want.TDEE},
+	}
+	for name, pair := range pairs {
+		if pair[0] == nil || pair[1] == nil || math.Abs(*pair[0]-*pair[1]) > 1e-9 {
+			t.Errorf("GET /api/analytics returned %s %v for %s, the table has %v", name, pair[0], date, pair[1])
+		}
+	}
+}
@@ -1,245 +1,391 @@
 package api
 
 import (
-	"database/sql"
+	"encoding/base64"
 	"errors"
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	"git.ebain.es/healthAndFitnessTracker/internal/database"
 	"git.ebain.es/healthAndFitnessTracker/internal/helpers"
 	"github.com/gin-gonic/gin"
-	"github.com/mattn/go-sqlite3"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-const dateFormat = "02/01/2006"
-const sqliteConnString = "file:/home/ebaines/Downloads/fitness.db"
-
-type dayRecord struct {
-	date     string
-	weight   sql.NullFloat64
-	calories sql.NullInt64
-}
-
-func parseDayJSON(json interface{}) (dayRecord, error) {
-	var record dayRecord
-
-	m := json.(map[string]interface{})
-
-	record.date = time.Now().Format(dateFormat)
-
-	for k, v := range m {
-		switch k {
-		case "time":
-			timestamp := v.(float64)
-			record.date = time.Unix(int64(timestamp), 0).Format(dateFormat)
-		case "weight":
-			weight := v.(float64)
-			record.weight.Float64 = helpers.RoundDecimalPlaces(weight, 1)
-			record.weight.Valid = true
-		case "calories":
-			calories := v.(float64)
-			record.calories.Int64 = int64(calories)
-			record.calories.Valid = true
-		default:
-			return record, errors.New("Invalid JSON - extra key")
+// Handler serves the day record endpoints using the loaded configuration and a shared store.
+type Handler struct {
+	cfg   *config.Config
+	store *database.Store
+	loc   *time.Location
+}
+
+func NewHandler(cfg *config.Config, store *database.Store) *Handler {
+	return &Handler{cfg: cfg, store: store, loc: cfg.Location()}
+}
+
+// calendarDay returns the calendar day that the instant t falls on in loc, as midnight UTC which is
+// how dates are stored.
+func calendarDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// location returns the timezone named in a request, falling back to the user's timezone and then the
+// configured default.
+func (h *Handler) location(c *gin.Context, tz *string) *time.Location {
+	if tz != nil {
+		if loc, err := time.LoadLocation(*tz); err == nil {
+			return loc
 		}
 	}
+	return UserLocation(CurrentUser(c), h.loc)
+}
 
-	return record, nil
+// dayResponse is the JSON representation of a day record. Missing values are serialised as null.
+type dayResponse struct {
+	ID       int64    `json:"id"`
+	Time     int64    `json:"time"`
+	Weight   *float64 `json:"weight"`
+	Calories *float64 `json:"calories"`
+}
 
+func newDayResponse(record database.DayRecord) dayResponse {
+	return dayResponse{
+		ID:       record.ID,
+		Time:     record.Time.Unix(),
+		Weight:   nullableFloat(record.Weight),
+		Calories: nullableFloat(record.Calories),
+	}
 }
 
-func handleSQLExecErr(c *gin.Context, err error) {
-	log.Print(err)
-	if sqliteErr, ok := err.(sqlite3.Error); ok {
-		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
-			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Day already exists"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
-		}
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+// dayRequest is the body accepted when creating or replacing a day record.
+type dayRequest struct {
+	Time     *int64   `json:"time" binding:"omitempty,min=0,notfuture"`
+	TZ       *string  `json:"tz" binding:"omitempty,timezone"`
+	Weight   *float64 `json:"weight" binding:"omitempty,gt=20,lte=500"`
+	Calories *float64 `json:"calories" binding:"omitempty,gte=0,lte=20000"`
+}
+
+// toRecord converts the request into a day record, defaulting to today when no time was given. The
+// timestamp is assigned to the calendar day it falls on in loc.
+func (req dayRequest) toRecord(loc *time.Location) database.DayRecord {
+	var record database.DayRecord
+
+	record.Time = calendarDay(time.Now(), loc)
+	if req.Time != nil {
+		record.Time = calendarDay(time.Unix(*req.Time, 0), loc)
 	}
+	setValues(&record, req.Weight, req.Calories)
+	return record
 }
 
-func AddWeight(c *gin.Context) {
-	db, err := sql.Open("sqlite3", sqliteConnString)
-	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
-		return
+// patchRequest is the body accepted by PATCH /api/weight/:id. Omitted fields are left unchanged.
+type patchRequest struct {
+	Time     *int64   `json:"time" binding:"omitempty,min=0,notfuture"`
+	TZ       *string  `json:"tz" binding:"omitempty,timezone"`
+	Weight   *float64 `json:"weight" binding:"omitempty,gt=20,lte=500"`
+	Calories *float64 `json:"calories" binding:"omitempty,gte=0,lte=20000"`
+}
+
+// dayValuesRequest is the body accepted by PUT /api/days/:date, where the date comes from the path.
+type dayValuesRequest struct {
+	Weight   *float64 `json:"weight" binding:"omitempty,gt=20,lte=500"`
+	Calories *float64 `json:"calories" binding:"omitempty,gte=0,lte=20000"`
+}
+
+// NewDayRecord builds a record for date from validated values, rounding them as the JSON API does.
+func NewDayRecord(date time.Time, weight *float64, calories *float64) database.DayRecord {
+	record := database.DayRecord{Time: date}
+	setValues(&record, weight, calories)
+	return record
+}
+
+func setValues(record *database.DayRecord, weight *float64, calories *float64) {
+	if weight != nil {
+		record.Weight.Float64 = helpers.RoundDecimalPlaces(*weight, 1)
+		record.Weight.Valid = true
+	}
+	if calories != nil {
+		record.Calories.Float64 = float64(int64(*calories))
+		record.Calories.Valid = true
 	}
-	defer db.Close()
+}
 
-	var json interface{}
+func parseDayJSON(body io.Reader) (dayRequest, error) {
+	var req dayRequest
 
-	if err := c.ShouldBindJSON(&json); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": err.Error()})
-		return
+	if err := parseRequest(body, &req); err != nil {
+		return req, err
 	}
+	return req, requireValues(req.Weight, req.Calories)
+}
 
-	record, err := parseDayJSON(json)
+func parseRequest(body io.Reader, req interface{}) error {
+	if err := decodeJSONBody(body, req); err != nil {
+		return err
+	}
+	return validateRequest(req)
+}
 
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": err.Error()})
+// requireValues rejects requests that would create a day with neither a weight nor calories.
+func requireValues(weight *float64, calories *float64) error {
+	if weight == nil && calories == nil {
+		return &requestError{
+			message: "Invalid request",
+			fields:  map[string]string{"weight": "is required when calories is missing"},
+		}
+	}
+	return nil
+}
+
+func respondRequestError(c *gin.Context, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) && reqErr.fields != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": reqErr.message, "fields": reqErr.fields})
 		return
 	}
+	c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": err.Error()})
+}
 
-	tx, err := db.Begin()
+func parseID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		log.Print(err)
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func handleSQLExecErr(c *gin.Context, err error) {
+	log.Print(err)
+	if errors.Is(err, database.ErrDuplicateDay) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Day already exists"})
+	} else if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"status": "failure", "error": "Day not found"})
+	} else if errors.Is(err, database.ErrMeasuredWeight) {
+		c.JSON(http.StatusConflict, gin.H{"status": "failure", "error": "The day's weight comes from its weigh-ins"})
+	} else {
 		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+	}
+}
+
+func (h *Handler) AddWeight(c *gin.Context) {
+	user := CurrentUser(c)
+
+	req, err := parseDayJSON(c.Request.Body)
+	if err != nil {
+		respondRequestError(c, err)
 		return
 	}
+	record := req.toRecord(h.location(c, req.TZ))
 
 	if c.Request.Method == "POST" {
-		stmt, err := tx.Prepare("INSERT INTO weight(date, weight_kg, calories_kcal) VALUES (?, ?, ?)")
-		if err != nil {
-			log.Print(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
-			return
-		}
-		defer stmt.Close()
-
-		_, err = stmt.Exec(record.date, record.weight, record.calories)
-		if err != nil {
-			handleSQLExecErr(c, err)
-			err = tx.Rollback()
-			if err != nil {
-				log.Print(err)
-			}
-			return
-		}
+		_, err = h.store.CreateDay(user.ID, record)
 	} else if c.Request.Method == "PUT" {
-		id := c.Param("id")
-
-		stmt, err := tx.Prepare("UPDATE weight SET date=?, weight_kg=?, calories_kcal=? WHERE id = ?")
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer stmt.Close()
-
-		_, err = stmt.Exec(record.date, record.weight, record.calories, id)
-		if err != nil {
-			handleSQLExecErr(c, err)
-			err = tx.Rollback()
-			if err != nil {
-				log.Print(err)
-			}
+		var ok bool
+		record.ID, ok = parseID(c)
+		if !ok {
 			return
 		}
+		err = h.store.UpdateDay(user.ID, record)
 	}
-
-	err = tx.Commit()
 	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+		handleSQLExecErr(c, err)
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"status": "success"})
 }
 
-func DeleteWeight(c *gin.Context) {
-	db, err := sql.Open("sqlite3", sqliteConnString)
-	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+// PatchWeight merges the fields present in the request into an existing day record.
+func (h *Handler) PatchWeight(c *gin.Context) {
+	user := CurrentUser(c)
+
+	id, ok := parseID(c)
+	if !ok {
 		return
 	}
-	defer db.Close()
 
-	id := c.Param("id")
+	var req patchRequest
+	if err := parseRequest(c.Request.Body, &req); err != nil {
+		respondRequestError(c, err)
+		return
+	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+	record := database.DayRecord{ID: id}
+	if req.Time != nil {
+		record.Time = calendarDay(time.Unix(*req.Time, 0), h.location(c, req.TZ))
+	}
+	setValues(&record, req.Weight, req.Calories)
+
+	if err := h.store.PatchDay(user.ID, record); err != nil {
+		handleSQLExecErr(c, err)
 		return
 	}
 
-	stmt, err := tx.Prepare("DELETE FROM weight WHERE id=?")
+	record, err := h.store.GetDay(user.ID, id)
 	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+		handleSQLExecErr(c, err)
 		return
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": newDayResponse(record)})
+}
+
+// PutDay creates or merges into the record for the YYYY-MM-DD date in the path, so clients can log
+// values without knowing the record's id. The date must not be after today in the user's timezone.
+func (h *Handler) PutDay(c *gin.Context) {
+	user := CurrentUser(c)
+
+	date, err := time.Parse(database.DateFormat, c.Param("date"))
 	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid date - expected YYYY-MM-DD"})
+		return
+	}
+	if isFutureDay(date, time.Now(), h.location(c, nil)) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid date - must not be in the future"})
 		return
 	}
 
-	err = tx.Commit()
+	var req dayValuesRequest
+	err = parseRequest(c.Request.Body, &req)
+	if err == nil {
+		err = requireValues(req.Weight, req.Calories)
+	}
 	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+		respondRequestError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	record := database.DayRecord{Time: date}
+	setValues(&record, req.Weight, req.Calories)
 
+	record, err = h.store.UpsertDay(user.ID, record)
+	if err != nil {
+		handleSQLExecErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": newDayResponse(record)})
 }
 
-func GetWeight(c *gin.Context) {
-	db, err := sql.Open("sqlite3", sqliteConnString)
-	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+func (h *Handler) DeleteWeight(c *gin.Context) {
+	user := CurrentUser(c)
+
+	id, ok := parseID(c)
+	if !ok {
 		return
 	}
-	defer db.Close()
 
-	id := c.Param("id")
+	if err := h.store.DeleteDay(user.ID, id); err != nil {
+		handleSQLExecErr(c, err)
+		return
+	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+
+}
+
+func (h *Handler) GetWeight(c *gin.Context) {
+	user := CurrentUser(c)
+
+	id, ok := parseID(c)
+	if !ok {
 		return
 	}
 
-	stmt, err := tx.Prepare("SELECT date, weight_kg, calories_kcal FROM weight WHERE id=?")
+	record, err := h.store.GetDay(user.ID, id)
 	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+		handleSQLExecErr(c, err)
 		return
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query(id)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": newDayResponse(record)})
+
+}
+
+const defaultListLimit = 100
+const maxListLimit = 1000
+
+// parseDateParam accepts either a YYYY-MM-DD date or a unix timestamp, which is assigned to its
+// calendar day in loc.
+func parseDateParam(value string, loc *time.Location) (time.Time, error) {
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return calendarDay(time.Unix(timestamp, 0), loc), nil
+	}
+	return time.Parse(database.DateFormat, value)
+}
+
+func encodeCursor(record database.DayRecord) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(record.Time.Format(database.DateFormat)))
+}
+
+func decodeCursor(cursor string) (time.Time, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
 	if err != nil {
-		log.Print(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
-		return
+		return time.Time{}, err
 	}
-	defer rows.Close()
+	return time.Parse(database.DateFormat, string(decoded))
+}
+
+// ListWeights returns the day records between the optional from and to dates in date order. Results
+// are paginated; when more records remain, next_cursor should be passed back as the cursor parameter.
+func (h *Handler) ListWeights(c *gin.Context) {
+	user := CurrentUser(c)
 
-	var date string
-	var weight float64
-	var calories float64
-	var timestamp int64
+	var query database.DayQuery
+	var err error
 
-	for rows.Next() {
-		err = rows.Scan(&date, &weight, &calories)
-		if err != nil {
-			log.Print(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+	if from := c.Query("from"); from != "" {
+		if query.From, err = parseDateParam(from, h.location(c, nil)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid from date"})
 			return
 		}
+	}
+	if to := c.Query("to"); to != "" {
+		if query.To, err = parseDateParam(to, h.location(c, nil)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid to date"})
+			return
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if query.After, err = decodeCursor(cursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid cursor"})
+			return
+		}
+	}
 
-		parsedTime, err := time.Parse(dateFormat, date)
-		if err != nil {
-			log.Print(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "Error parsing data from database."})
+	limit := defaultListLimit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "limit must be between 1 and " + strconv.Itoa(maxListLimit)})
 			return
 		}
-		timestamp = parsedTime.Unix()
+	}
+	// Fetch one extra record to find out whether there is another page.
+	query.Limit = limit + 1
+
+	records, err := h.store.QueryDays(user.ID, query)
+	if err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+		return
+	}
+
+	var nextCursor *string
+	if len(records) > limit {
+		records = records[:limit]
+		cursor := encodeCursor(records[limit-1])
+		nextCursor = &cursor
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success", "data": gin.H{"time": timestamp, "weight": weight, "calories": calories}})
+	data := make([]dayResponse, 0, len(records))
+	for _, record := range records {
+		data = append(data, newDayResponse(record))
+	}
 
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": data, "next_cursor": nextCursor})
 }
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	"git.ebain.es/healthAndFitnessTracker/internal/database"
+	"github.com/gin-gonic/gin"
+	_ "time/tzdata"
+)
+
+const testUsername = "alice"
+const testPassword = "correct horse"
+
+// testToken is a full access token for the test user, set by newTestRouter. Requests use it rather than
+// basic auth, which runs bcrypt on every request.
+var testToken string
+
+func newTestRouter(t *testing.T) (*gin.Engine, *database.Store) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := database.Open(database.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := store.CreateUser(testUsername, testPassword, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testToken, _, err = store.CreateToken(user.ID, "test", []string{database.ScopeRead, database.ScopeWrite, database.ScopeDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	days := NewHandler(config.Default(), store)
+	r := gin.New()
+	r.Use(RequireUser(store))
+	r.GET("/api/weight", days.ListWeights)
+	r.GET("/api/weight/:id", days.GetWeight)
+	r.POST("/api/weight", days.AddWeight)
+	r.PUT("/api/weight/:id", days.AddWeight)
+	r.PATCH("/api/weight/:id", days.PatchWeight)
+	r.DELETE("/api/weight/:id", days.DeleteWeight)
+	r.PUT("/api/days/:date", days.PutDay)
+	r.GET("/api/analytics", days.Analytics)
+	r.GET("/api/export/:file", days.Export)
+	r.POST("/api/import", days.Import)
+	r.POST("/api/measurements", days.AddMeasurement)
+	r.GET("/api/weight/:id/measurements", days.ListMeasurements)
+	r.DELETE("/api/measurements/:id", days.DeleteMeasurement)
+	return r, store
+}
+
+type testResponse struct {
+	Status string            `json:"status"`
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields"`
+	Data   json.RawMessage   `json:"data"`
+}
+
+func doRequest(t *testing.T, r http.Handler, method string, path string, body string) (int, testResponse) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response testResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s %s returned invalid JSON %q: %v", method, path, w.Body.String(), err)
+	}
+	return w.Code, response
+}
+
+func TestAddWeightValidation(t *testing.T) {
+	farFuture := strconv.FormatInt(time.Now().Add(30*24*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantField  string
+	}{
+		{"valid", `{"time": 1614600000, "weight": 80.25, "calories": 2500}`, http.StatusCreated, ""},
+		{"weight only", `{"time": 1614700000, "weight": 80.3}`, http.StatusCreated, ""},
+		{"string weight", `{"weight": "80"}`, http.StatusBadRequest, "weight"},
+		{"string time", `{"time": "yesterday", "weight": 80}`, http.StatusBadRequest, "time"},
+		{"fractional time", `{"time": 1614600000.5, "weight": 80}`, http.StatusBadRequest, "time"},
+		{"array body", `[80, 2500]`, http.StatusBadRequest, ""},
+		{"bare number", `80`, http.StatusBadRequest, ""},
+		{"empty body", ``, http.StatusBadRequest, ""},
+		{"truncated", `{"weight": 80`, http.StatusBadRequest, ""},
+		{"trailing data", `{"weight": 80} {}`, http.StatusBadRequest, ""},
+		{"extra key", `{"weight": 80, "mood": "good"}`, http.StatusBadRequest, "mood"},
+		{"no values", `{"time": 1614600000}`, http.StatusBadRequest, "weight"},
+		{"weight too low", `{"weight": 0}`, http.StatusBadRequest, "weight"},
+		{"weight too high", `{"weight": 900}`, http.StatusBadRequest, "weight"},
+		{"negative calories", `{"weight": 80, "calories": -100}`, http.StatusBadRequest, "calories"},
+		{"negative time", `{"time": -5, "weight": 80}`, http.StatusBadRequest, "time"},
+		{"far future", `{"time": ` + farFuture + `, "weight": 80}`, http.StatusBadRequest, "time"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, store := newTestRouter(t)
+			defer store.Close()
+
+			status, response := doRequest(t, r, http.MethodPost, "/api/weight", tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("POST returned %v not %v: %+v", status, tt.wantStatus, response)
+			}
+			if tt.wantField != "" && response.Fields[tt.wantField] == "" {
+				t.Errorf("POST did not report an error for %q: %+v", tt.wantField, response)
+			}
+		})
+	}
+}
+
+func TestGetWeightNotFound(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	status, response := doRequest(t, r, http.MethodGet, "/api/weight/42", "")
+	if status != http.StatusNotFound {
+		t.Errorf("GET returned %v not 404", status)
+	}
+	if response.Status != "failure" || response.Error == "" {
+		t.Errorf("GET returned %+v without an error", response)
+	}
+}
+
+func TestPatchWeightKeepsOtherFields(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	status, _ := doRequest(t, r, http.MethodPost, "/api/weight", `{"time": 1614600000, "weight": 80.2, "calories": 2500}`)
+	if status != http.StatusCreated {
+		t.Fatalf("POST returned %v", status)
+	}
+
+	status, response := doRequest(t, r, http.MethodPatch, "/api/weight/1", `{"weight": 79.9}`)
+	if status != http.StatusOK {
+		t.Fatalf("PATCH returned %v: %+v", status, response)
+	}
+
+	var day dayResponse
+	if err := json.Unmarshal(response.Data, &day); err != nil {
+		t.Fatal(err)
+	}
+	if day.Weight == nil || *day.Weight != 79.9 || day.Calories == nil || *day.Calories != 2500 {
+		t.Errorf("PATCH left %s", response.Data)
+	}
+
+	status, _ = doRequest(t, r, http.MethodPatch, "/api/weight/2", `{"weight": 79.9}`)
+	if status != http.StatusNotFound {
+		t.Errorf("PATCH on a missing id returned %v not 404", status)
+	}
+}
+
+func TestPutDayUpsertsByDate(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	status, response := doRequest(t, r, http.MethodPut, "/api/days/2021-03-01", `{"weight": 80.2}`)
+	if status != http.StatusOK {
+		t.Fatalf("PUT returned %v: %+v", status, response)
+	}
+	status, response = doRequest(t, r, http.MethodPut, "/api/days/2021-03-01", `{"calories": 2600}`)
+	if status != http.StatusOK {
+		t.Fatalf("PUT returned %v: %+v", status, response)
+	}
+
+	var day dayResponse
+	if err := json.Unmarshal(response.Data, &day); err != nil {
+		t.Fatal(err)
+	}
+	if day.ID != 1 || day.Weight == nil || *day.Weight != 80.2 || day.Calories == nil || *day.Calories != 2600 {
+		t.Errorf("PUT merged into %s", response.Data)
+	}
+
+	status, _ = doRequest(t, r, http.MethodPut, "/api/days/01-03-2021", `{"calories": 2600}`)
+	if status != http.StatusBadRequest {
+		t.Errorf("PUT with a bad date returned %v not 400", status)
+	}
+
+	status, _ = doRequest(t, r, http.MethodPut, "/api/days/2099-01-01", `{"calories": 2600}`)
+	if status != http.StatusBadRequest {
+		t.Errorf("PUT with a future date returned %v not 400", status)
+	}
+	user, err := store.GetUserByName(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetDayByDate(user.ID, time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("PUT with a future date stored a day: %v", err)
+	}
+}
+
+func TestCalendarDay(t *testing.T) {
+	tests := []struct {
+		name    string
+		instant string
+		zone    string
+		want    string
+	}{
+		{"utc midnight", "2021-01-01T00:00:00Z", "UTC", "2021-01-01"},
+		{"utc just before midnight", "2020-12-31T23:59:59Z", "UTC", "2020-12-31"},
+		{"london before spring forward", "2021-03-27T23:30:00Z", "Europe/London", "2021-03-27"},
+		{"london during spring forward night", "2021-03-28T00:30:00Z", "Europe/London", "2021-03-28"},
+		{"london first bst midnight", "2021-03-28T23:30:00Z", "Europe/London", "2021-03-29"},
+		{"london last bst midnight", "2021-10-30T23:30:00Z", "Europe/London", "2021-10-31"},
+		{"london repeated hour", "2021-10-31T01:30:00Z", "Europe/London", "2021-10-31"},
+		{"london after fall back", "2021-10-31T23:30:00Z", "Europe/London", "2021-10-31"},
+		{"new york before midnight on dst day", "2021-03-15T03:59:00Z", "America/New_York", "2021-03-14"},
+		{"new york midnight after dst day", "2021-03-15T04:00:00Z", "America/New_York", "2021-03-15"},
+		{"auckland ahead of utc", "2021-04-03T11:30:00Z", "Pacific/Auckland", "2021-04-04"},
+		{"auckland after dst ends", "2021-04-04T12:30:00Z", "Pacific/Auckland", "2021-04-05"},
+		{"kolkata half hour offset", "2021-06-01T18:30:00Z", "Asia/Kolkata", "2021-06-02"},
+		{"kolkata just before midnight", "2021-06-01T18:29:59Z", "Asia/Kolkata", "2021-06-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instant, err := time.Parse(time.RFC3339, tt.instant)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			day := calendarDay(instant, loc)
+			if got := day.Format(database.DateFormat); got != tt.want {
+				t.Errorf("calendarDay(%v, %v) returned %v not %v", tt.instant, tt.zone, got, tt.want)
+			}
+			if day.Location() != time.UTC || day.Hour() != 0 {
+				t.Errorf("calendarDay returned %v rather than midnight UTC", day)
+			}
+		})
+	}
+}
+
+func TestAddWeightUsesRequestTimezone(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	// 23:30 UTC on the 1st is already the morning of the 2nd in Tokyo.
+	instant := time.Date(2021, 3, 1, 23, 30, 0, 0, time.UTC).Unix()
+	body := `{"time": ` + strconv.FormatInt(instant, 10) + `, "tz": "Asia/Tokyo", "weight": 80}`
+	status, response := doRequest(t, r, http.MethodPost, "/api/weight", body)
+	if status != http.StatusCreated {
+		t.Fatalf("POST returned %v: %+v", status, response)
+	}
+
+	record, err := store.GetDay(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := record.Time.Format(database.DateFormat); got != "2021-03-02" {
+		t.Errorf("POST stored the day as %v not 2021-03-02", got)
+	}
+
+	status, response = doRequest(t, r, http.MethodPost, "/api/weight", `{"weight": 80, "tz": "Mars/Olympus_Mons"}`)
+	if status != http.StatusBadRequest || response.Fields["tz"] == "" {
+		t.Errorf("POST with an unknown timezone returned %v: %+v", status, response)
+	}
+}
+
+func TestRequestsRequireAuthentication(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/weight", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET without credentials returned %v not 401", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/weight", nil)
+	req.SetBasicAuth(testUsername, "wrong password")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET with a wrong password returned %v not 401", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/weight", nil)
+	req.SetBasicAuth(testUsername, testPassword)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET with the password returned %v not 200", w.Code)
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	user, err := store.GetUserByName(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := store.CreateToken(user.ID, "phone", []string{database.ScopeRead, database.ScopeWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{http.MethodPost, "/api/weight", `{"time": 1614600000, "weight": 80}`, http.StatusCreated},
+		{http.MethodGet, "/api/weight/1", "", http.StatusOK},
+		{http.MethodDelete, "/api/weight/1", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s %s with a read/write token returned %v not %v", tt.method, tt.path, w.Code, tt.wantStatus)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/weight/1", nil)
+	req.Header.Set("Authorization", "Bearer bt_not-a-real-token")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET with an unknown token returned %v not 401", w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/analysis"
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	"git.ebain.es/healthAndFitnessTracker/internal/export"
+	"github.com/gin-gonic/gin"
+)
+
+// ExportOptions returns the export options set in cfg.
+func ExportOptions(cfg *config.Config) export.Options {
+	return export.Options{DateFormat: cfg.ExportDateFormat, DecimalPlaces: cfg.ExportDecimalPlaces}
+}
+
+// Export returns a spreadsheet of the user's days between the optional from and to dates. The file
+// parameter selects the data and format: days.csv, days.xlsx, analytics.csv or analytics.xlsx. The
+// date_format (a Go layout) and decimals query parameters override the configured defaults.
+func (h *Handler) Export(c *gin.Context) {
+	file := c.Param("file")
+	dataset := strings.TrimSuffix(file, path.Ext(file))
+	format, err := export.ParseFormat(strings.TrimPrefix(path.Ext(file), "."))
+	if err != nil || (dataset != "days" && dataset != "analytics") {
+		c.JSON(http.StatusNotFound, gin.H{"status": "failure", "error": "Unknown export " + file})
+		return
+	}
+
+	opts := ExportOptions(h.cfg)
+	if dateFormat := c.Query("date_format"); dateFormat != "" {
+		opts.DateFormat = dateFormat
+	}
+	if decimals := c.Query("decimals"); decimals != "" {
+		opts.DecimalPlaces, err = strconv.Atoi(decimals)
+		if err != nil || opts.DecimalPlaces < 0 || opts.DecimalPlaces > config.MaxDecimalPlaces {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "decimals must be between 0 and " + strconv.Itoa(config.MaxDecimalPlaces)})
+			return
+		}
+	}
+
+	from, to, ok := h.parseRange(c)
+	if !ok {
+		return
+	}
+
+	var table *export.Table
+	userID := CurrentUser(c).ID
+	if dataset == "days" {
+		var records []database.DayRecord
+		records, err = h.store.QueryDays(userID, database.DayQuery{From: from, To: to})
+		if err == nil {
+			table = export.Days(records, opts)
+		}
+	} else {
+		var report *analysis.Report
+		report, err = h.report(userID, from, to)
+		if err == nil {
+			table = export.Report(report, opts)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "Export failed"})
+		return
+	}
+
+	var buffer bytes.Buffer
+	if err := format.Write(&buffer, table); err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "Export failed"})
+		return
+	}
+
+	filename := dataset + "-" + time.Now().Format(database.DateFormat) + "." + format.Extension
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, format.ContentType, buffer.Bytes())
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+)
+
+func TestExport(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	user, err := store.GetUserByName(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := database.DayRecord{
+		Time:     time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		Weight:   sql.NullFloat64{Float64: 80.123, Valid: true},
+		Calories: sql.NullFloat64{Float64: 2500, Valid: true},
+	}
+	if _, err := store.CreateDay(user.ID, record); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		contentType string
+		body        string
+	}{
+		{"/api/export/days.csv", http.StatusOK, "text/csv; charset=utf-8", "date,weight_kg,calories_kcal\n2021-03-01,80.12,2500\n"},
+		{"/api/export/days.csv?decimals=0&date_format=02/01/2006", http.StatusOK, "text/csv; charset=utf-8", "date,weight_kg,calories_kcal\n01/03/2021,80,2500\n"},
+		{"/api/export/days.csv?from=2021-03-02", http.StatusOK, "text/csv; charset=utf-8", "date,weight_kg,calories_kcal\n"},
+		{"/api/export/analytics.csv", http.StatusOK, "text/csv; charset=utf-8", ""},
+		{"/api/export/days.xlsx", http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ""},
+		{"/api/export/days.pdf", http.StatusNotFound, "", ""},
+		{"/api/export/weights.csv", http.StatusNotFound, "", ""},
+		{"/api/export/days.csv?decimals=-1", http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != test.wantStatus {
+			t.Errorf("GET %s returned %d not %d", test.path, w.Code, test.wantStatus)
+			continue
+		}
+		if test.contentType != "" && w.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("GET %s returned content type %q not %q", test.path, w.Header().Get("Content-Type"), test.contentType)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("GET %s returned %q not %q", test.path, w.Body.String(), test.body)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	"git.ebain.es/healthAndFitnessTracker/internal/importer"
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize is the largest import file accepted, which is several lifetimes of daily records.
+const maxImportSize = 10 << 20
+
+// ImportReport describes the outcome of an import. Nothing is written when the import is a dry run
+// or any row is invalid.
+type ImportReport struct {
+	Written   bool                `json:"written"`
+	Inserted  int                 `json:"inserted"`
+	Updated   int                 `json:"updated"`
+	Skipped   int                 `json:"skipped"`
+	Invalid   []importer.RowError `json:"invalid"`
+	Conflicts []ImportConflict    `json:"conflicts"`
+}
+
+// ImportConflict is an imported row for a date that already had a record.
+type ImportConflict struct {
+	Line       int                   `json:"line,omitempty"`
+	Date       string                `json:"date"`
+	Existing   dayResponse           `json:"existing"`
+	Imported   dayResponse           `json:"imported"`
+	Resolution database.ConflictMode `json:"resolution"`
+}
+
+// ImportRows validates rows with the JSON API's rules, rejecting dates after today in loc, and writes them
+// for the user in a single transaction, resolving dates that already have a record according to mode. Rows
+// that would change the weight of a day with weigh-ins are invalid. The rows are only written if every row,
+// including those in invalid, is valid and dryRun is false.
+func ImportRows(store *database.Store, userID int64, rows []importer.Row, invalid []importer.RowError, loc *time.Location, mode database.ConflictMode, dryRun bool) (*ImportReport, error) {
+	report := ImportReport{Invalid: invalid, Conflicts: []ImportConflict{}}
+	if report.Invalid == nil {
+		report.Invalid = []importer.RowError{}
+	}
+
+	records := make([]database.DayRecord, 0, len(rows))
+	lines := make([]int, 0, len(rows))
+	now := time.Now()
+	for _, row := range rows {
+		if isFutureDay(row.Date, now, loc) {
+			report.Invalid = append(report.Invalid, importer.RowError{Line: row.Line, Message: "date must not be in the future"})
+			continue
+		}
+		if err := ValidateDayValues(row.Weight, row.Calories); err != nil {
+			report.Invalid = append(report.Invalid, importer.RowError{Line: row.Line, Message: rowErrorMessage(err)})
+			continue
+		}
+		records = append(records, NewDayRecord(row.Date, row.Weight, row.Calories))
+		lines = append(lines, row.Line)
+	}
+
+	write := !dryRun && len(report.Invalid) == 0
+	result, err := store.ImportDays(userID, records, mode, !write)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Written = write
+	report.Inserted = result.Inserted
+	report.Updated = result.Updated
+	report.Skipped = result.Skipped
+	for _, conflict := range result.Conflicts {
+		if conflict.Measured {
+			report.Written = false
+			report.Invalid = append(report.Invalid, importer.RowError{Line: lines[conflict.Index], Message: "weight differs from the day's weigh-ins"})
+		}
+		imported := records[conflict.Index]
+		report.Conflicts = append(report.Conflicts, ImportConflict{
+			Line:       lines[conflict.Index],
+			Date:       imported.Time.Format(database.DateFormat),
+			Existing:   newDayResponse(conflict.Existing),
+			Imported:   newDayResponse(imported),
+			Resolution: mode,
+		})
+	}
+	sort.Slice(report.Invalid, func(i, j int) bool {
+		return report.Invalid[i].Line < report.Invalid[j].Line
+	})
+	return &report, nil
+}
+
+// isFutureDay reports whether date, a calendar day at midnight UTC like the importers produce, falls after
+// the day it is at now in loc.
+func isFutureDay(date time.Time, now time.Time, loc *time.Location) bool {
+	return !date.Before(calendarDay(now, loc).AddDate(0, 0, 1))
+}
+
+// rowErrorMessage flattens a validation error into a single line, e.g. "weight must be greater than 20".
+func rowErrorMessage(err error) string {
+	fields := FieldErrors(err)
+	messages := make([]string, 0, len(fields))
+	for field, message := range fields {
+		messages = append(messages, strings.TrimSpace(field+" "+message))
+	}
+	sort.Strings(messages)
+	return strings.Join(messages, "; ")
+}
+
+// Import reads a file of day records from the request body or a multipart "file" field and imports it
+// for the user. The query parameters configure the import:
+//
+//	format                                       csv (default), apple-health, google-fit, fitbit or garmin
+//	weight_unit                                  unit of weights in fitbit files: kg (default) or lb
+//	date_column, weight_column, calories_column  header names of the CSV columns (calories_column= skips it)
+//	date_format                                  Go layouts to parse dates with; may be repeated
+//	delimiter                                    field delimiter, default ","
+//	tz                                           timezone for dates with a time of day
+//	conflict                                     skip (default), overwrite or merge existing days
+//	dry_run                                      true to report what would happen without writing
+func (h *Handler) Import(c *gin.Context) {
+	opts := importer.CSVOptions{
+		Mapping:     importer.DefaultMapping,
+		DateFormats: c.QueryArray("date_format"),
+	}
+	if column, ok := c.GetQuery("date_column"); ok {
+		opts.Mapping.Date = column
+	}
+	if column, ok := c.GetQuery("weight_column"); ok {
+		opts.Mapping.Weight = column
+	}
+	if column, ok := c.GetQuery("calories_column"); ok {
+		opts.Mapping.Calories = column
+	}
+	if delimiter := c.Query("delimiter"); delimiter != "" {
+		if utf8.RuneCountInString(delimiter) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "delimiter must be a single character"})
+			return
+		}
+		opts.Comma, _ = utf8.DecodeRuneInString(delimiter)
+	}
+	if tz, ok := c.GetQuery("tz"); ok {
+		if !isTimezone(tz) {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid timezone"})
+			return
+		}
+		opts.Location = h.location(c, &tz)
+	} else {
+		opts.Location = h.location(c, nil)
+	}
+
+	mode := database.ConflictSkip
+	if conflict := c.Query("conflict"); conflict != "" {
+		var err error
+		if mode, err = database.ParseConflictMode(conflict); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "conflict must be skip, overwrite or merge"})
+			return
+		}
+	}
+	format := c.DefaultQuery("format", "csv")
+	parser, ok := importer.Parsers[format]
+	if !ok && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Unknown format " + format})
+		return
+	}
+	var weightUnit importer.Unit
+	if unit := c.Query("weight_unit"); unit != "" {
+		var err error
+		if weightUnit, err = importer.ParseUnit(unit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "Invalid weight_unit"})
+			return
+		}
+	}
+
+	dryRun := false
+	if dryRunParam := c.Query("dry_run"); dryRunParam != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(dryRunParam); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "dry_run must be true or false"})
+			return
+		}
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		file, _, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": "The form must include a file field"})
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	var rows []importer.Row
+	var invalid []importer.RowError
+	var err error
+	if parser != nil {
+		rows, invalid, err = parser(body, importer.Options{Location: opts.Location, WeightUnit: weightUnit})
+	} else {
+		rows, invalid, err = importer.ParseCSV(body, opts)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failure", "error": strings.TrimPrefix(err.Error(), "importer: ")})
+		return
+	}
+
+	report, err := ImportRows(h.store, CurrentUser(c).ID, rows, invalid, opts.Location, mode, dryRun)
+	if err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+		return
+	}
+
+	if len(report.Invalid) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"status": "failure", "error": "Some rows are invalid", "data": report})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": report})
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImport(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	user, err := store.GetUserByName(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, response := doRequest(t, r, http.MethodPut, "/api/days/2021-03-01", `{"weight": 80, "calories": 2400}`); status != http.StatusOK {
+		t.Fatalf("PUT /api/days returned %d: %s", status, response.Error)
+	}
+
+	file := "Date,Weight\n01/03/2021,79.55\n02/03/2021,79.4\n"
+	path := "/api/import?date_column=Date&weight_column=Weight&calories_column=&date_format=02/01/2006&conflict=merge"
+
+	status, response := doRequest(t, r, http.MethodPost, path+"&dry_run=true", file)
+	if status != http.StatusOK {
+		t.Fatalf("POST /api/import dry run returned %d: %s", status, response.Error)
+	}
+	var report ImportReport
+	if err := json.Unmarshal(response.Data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Written || report.Inserted != 1 || report.Updated != 1 || len(report.Conflicts) != 1 || report.Conflicts[0].Line != 2 {
+		t.Errorf("POST /api/import dry run returned %+v", report)
+	}
+	if records, _ := store.ListDays(user.ID, 10); len(records) != 1 {
+		t.Errorf("POST /api/import dry run wrote %d records", len(records)-1)
+	}
+
+	status, response = doRequest(t, r, http.MethodPost, path, file)
+	if status != http.StatusOK {
+		t.Fatalf("POST /api/import returned %d: %s", status, response.Error)
+	}
+	merged, err := store.GetDayByDate(user.ID, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Imported weights are rounded like the JSON API's.
+	if merged.Weight.Float64 != 79.6 || merged.Calories.Float64 != 2400 {
+		t.Errorf("POST /api/import merged into %+v", merged)
+	}
+
+	// A single invalid row stops the whole file from being written.
+	status, response = doRequest(t, r, http.MethodPost, "/api/import?calories_column=", "date,weight_kg\n2021-03-05,79\n2021-03-06,5\n")
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("POST /api/import with an invalid row returned %d not %d", status, http.StatusUnprocessableEntity)
+	}
+	if err := json.Unmarshal(response.Data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Written || len(report.Invalid) != 1 || report.Invalid[0].Line != 3 {
+		t.Errorf("POST /api/import with an invalid row returned %+v", report)
+	}
+	if records, _ := store.ListDays(user.ID, 10); len(records) != 2 {
+		t.Errorf("POST /api/import with an invalid row left %d records not %d", len(records), 2)
+	}
+
+	// Rows dated after today are rejected like the JSON API's future timestamps.
+	nextYear := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+	status, response = doRequest(t, r, http.MethodPost, "/api/import?calories_column=", "date,weight_kg\n2021-03-05,79\n"+nextYear+",79\n")
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("POST /api/import with a future row returned %d not %d", status, http.StatusUnprocessableEntity)
+	}
+	report = ImportReport{}
+	if err := json.Unmarshal(response.Data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Written || len(report.Invalid) != 1 || report.Invalid[0].Line != 3 || report.Invalid[0].Message != "date must not be in the future" {
+		t.Errorf("POST /api/import with a future row returned %+v", report)
+	}
+
+	for _, path := range []string{"/api/import?conflict=replace", "/api/import?dry_run=maybe", "/api/import?date_column=Day"} {
+		if status, _ := doRequest(t, r, http.MethodPost, path, file); status != http.StatusBadRequest {
+			t.Errorf("POST %s returned %d not %d", path, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestImportAppFormat(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	file, err := ioutil.ReadFile(filepath.Join("..", "importer", "testdata", "fitbit_food_log.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, response := doRequest(t, r, http.MethodPost, "/api/import?format=fitbit", string(file))
+	if status != http.StatusOK {
+		t.Fatalf("POST /api/import?format=fitbit returned %d: %s", status, response.Error)
+	}
+	var report ImportReport
+	if err := json.Unmarshal(response.Data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Written || report.Inserted != 2 {
+		t.Errorf("POST /api/import?format=fitbit returned %+v", report)
+	}
+
+	if status, _ := doRequest(t, r, http.MethodPost, "/api/import?format=myfitnesspal", string(file)); status != http.StatusBadRequest {
+		t.Errorf("POST /api/import with an unknown format returned %d not %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestIsFutureDay(t *testing.T) {
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 03:00 UTC on the 19th is still the evening of the 18th in Los Angeles and the afternoon of the 19th in
+	// Auckland.
+	now := time.Date(2026, 10, 19, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date   string
+		loc    *time.Location
+		future bool
+	}{
+		{"2026-10-18", losAngeles, false},
+		{"2026-10-19", losAngeles, true},
+		{"2026-10-19", auckland, false},
+		{"2026-10-20", auckland, true},
+		{"2026-10-19", time.UTC, false},
+	}
+	for _, test := range tests {
+		date, err := time.Parse("2006-01-02", test.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isFutureDay(date, now, test.loc); got != test.future {
+			t.Errorf("isFutureDay(%v) in %v returned %v not %v", test.date, test.loc, got, test.future)
+		}
+	}
+}
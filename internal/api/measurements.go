@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/database"
+	"git.ebain.es/healthAndFitnessTracker/internal/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// measurementRequest is the body accepted by POST /api/measurements.
+type measurementRequest struct {
+	Time   *int64   `json:"time" binding:"omitempty,min=0,notfuture"`
+	TZ     *string  `json:"tz" binding:"omitempty,timezone"`
+	Weight *float64 `json:"weight" binding:"required,gt=20,lte=500"`
+}
+
+// measurementResponse is the JSON representation of a weigh-in.
+type measurementResponse struct {
+	ID     int64   `json:"id"`
+	DayID  int64   `json:"day_id"`
+	Time   int64   `json:"time"`
+	Weight float64 `json:"weight"`
+}
+
+func newMeasurementResponse(m database.Measurement) measurementResponse {
+	return measurementResponse{ID: m.ID, DayID: m.DayID, Time: m.Time.Unix(), Weight: m.Weight}
+}
+
+// AddMeasurement records a weigh-in at the given time, defaulting to now, on the calendar day it falls
+// on. A day can have any number of weigh-ins, which are combined into the day's weight when it is read.
+func (h *Handler) AddMeasurement(c *gin.Context) {
+	user := CurrentUser(c)
+
+	var req measurementRequest
+	if err := parseRequest(c.Request.Body, &req); err != nil {
+		respondRequestError(c, err)
+		return
+	}
+
+	measuredAt := time.Now()
+	if req.Time != nil {
+		measuredAt = time.Unix(*req.Time, 0)
+	}
+	date := calendarDay(measuredAt, h.location(c, req.TZ))
+
+	m, err := h.store.AddMeasurement(user.ID, date, measuredAt, helpers.RoundDecimalPlaces(*req.Weight, 1))
+	if err != nil {
+		handleSQLExecErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "success", "data": newMeasurementResponse(m)})
+}
+
+// ListMeasurements returns the weigh-ins of a day record in the order they were measured.
+func (h *Handler) ListMeasurements(c *gin.Context) {
+	user := CurrentUser(c)
+
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	measurements, err := h.store.ListMeasurements(user.ID, id)
+	if err != nil {
+		handleSQLExecErr(c, err)
+		return
+	}
+
+	data := make([]measurementResponse, 0, len(measurements))
+	for _, m := range measurements {
+		data = append(data, newMeasurementResponse(m))
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": data})
+}
+
+// DeleteMeasurement removes a weigh-in. The day record itself is kept.
+func (h *Handler) DeleteMeasurement(c *gin.Context) {
+	user := CurrentUser(c)
+
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteMeasurement(user.ID, id); errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"status": "failure", "error": "Measurement not found"})
+		return
+	} else if err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failure", "error": "SQL error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
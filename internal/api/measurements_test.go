@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMeasurements(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	morning := time.Date(2021, 3, 1, 7, 0, 0, 0, time.UTC)
+	evening := morning.Add(14 * time.Hour)
+
+	var added []measurementResponse
+	for _, weighIn := range []struct {
+		time   time.Time
+		weight string
+	}{{evening, "81.04"}, {morning, "80.2"}} {
+		body := `{"time": ` + strconv.FormatInt(weighIn.time.Unix(), 10) + `, "tz": "UTC", "weight": ` + weighIn.weight + `}`
+		status, response := doRequest(t, r, http.MethodPost, "/api/measurements", body)
+		if status != http.StatusCreated {
+			t.Fatalf("POST /api/measurements returned %d: %s", status, response.Error)
+		}
+		var m measurementResponse
+		if err := json.Unmarshal(response.Data, &m); err != nil {
+			t.Fatal(err)
+		}
+		added = append(added, m)
+	}
+	if added[0].DayID != added[1].DayID || added[0].Weight != 81.0 {
+		t.Errorf("POST /api/measurements returned %+v", added)
+	}
+
+	status, response := doRequest(t, r, http.MethodGet, "/api/weight/"+strconv.FormatInt(added[0].DayID, 10), "")
+	if status != http.StatusOK {
+		t.Fatalf("GET /api/weight/:id returned %d: %s", status, response.Error)
+	}
+	var day dayResponse
+	if err := json.Unmarshal(response.Data, &day); err != nil {
+		t.Fatal(err)
+	}
+	if day.Weight == nil || *day.Weight != 80.2 {
+		t.Errorf("GET /api/weight/:id returned %+v, expected the morning weight", day)
+	}
+
+	// The day's weight comes from its weigh-ins, so patching a different one is a conflict.
+	dayPath := "/api/weight/" + strconv.FormatInt(added[0].DayID, 10)
+	if status, _ := doRequest(t, r, http.MethodPatch, dayPath, `{"weight": 75}`); status != http.StatusConflict {
+		t.Errorf("PATCH %s with a new weight returned %d not %d", dayPath, status, http.StatusConflict)
+	}
+	if status, response := doRequest(t, r, http.MethodPatch, dayPath, `{"calories": 2500}`); status != http.StatusOK {
+		t.Errorf("PATCH %s with calories returned %d: %s", dayPath, status, response.Error)
+	}
+
+	path := "/api/weight/" + strconv.FormatInt(added[0].DayID, 10) + "/measurements"
+	status, response = doRequest(t, r, http.MethodGet, path, "")
+	if status != http.StatusOK {
+		t.Fatalf("GET %s returned %d: %s", path, status, response.Error)
+	}
+	var measurements []measurementResponse
+	if err := json.Unmarshal(response.Data, &measurements); err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != 2 || measurements[0].Time != morning.Unix() {
+		t.Errorf("GET %s returned %+v", path, measurements)
+	}
+
+	deletePath := "/api/measurements/" + strconv.FormatInt(added[1].ID, 10)
+	if status, _ := doRequest(t, r, http.MethodDelete, deletePath, ""); status != http.StatusOK {
+		t.Errorf("DELETE %s returned %d", deletePath, status)
+	}
+	if status, _ := doRequest(t, r, http.MethodDelete, deletePath, ""); status != http.StatusNotFound {
+		t.Errorf("DELETE %s twice returned %d not %d", deletePath, status, http.StatusNotFound)
+	}
+
+	if status, _ := doRequest(t, r, http.MethodPost, "/api/measurements", `{"calories": 2500}`); status != http.StatusBadRequest {
+		t.Errorf("POST /api/measurements without a weight returned %d not %d", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// maxFutureSkew is how far ahead of the server clock a submitted timestamp may be, allowing for
+// clients in timezones ahead of the server.
+const maxFutureSkew = 36 * time.Hour
+
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		// Report fields by their JSON names so that error messages match the request body.
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+		_ = v.RegisterValidation("notfuture", notFuture)
+		_ = v.RegisterValidation("timezone", validTimezone)
+	}
+}
+
+func notFuture(fl validator.FieldLevel) bool {
+	return time.Unix(fl.Field().Int(), 0).Before(time.Now().Add(maxFutureSkew))
+}
+
+func validTimezone(fl validator.FieldLevel) bool {
+	return isTimezone(fl.Field().String())
+}
+
+// isTimezone reports whether name is an IANA timezone a request may ask for.
+func isTimezone(name string) bool {
+	// LoadLocation treats "" as UTC and "Local" as the server's zone; neither is a useful request value.
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// requestError describes why a request body was rejected. Fields maps JSON field names to messages.
+type requestError struct {
+	message string
+	fields  map[string]string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func decodeJSONBody(body io.Reader, dest interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dest)
+	if err == nil {
+		if decoder.More() {
+			return &requestError{message: "Invalid JSON - trailing data"}
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return &requestError{message: "Invalid JSON - empty body"}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return &requestError{message: "Invalid JSON"}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return &requestError{message: "Invalid JSON - expected an object"}
+		}
+		return &requestError{
+			message: "Invalid request",
+			fields:  map[string]string{typeErr.Field: "must be a " + jsonTypeName(typeErr.Type)},
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &requestError{
+			message: "Invalid JSON - extra key",
+			fields:  map[string]string{field: "unknown field"},
+		}
+	}
+	return &requestError{message: err.Error()}
+}
+
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return "whole number"
+	case reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	}
+	return t.Kind().String()
+}
+
+// validateRequest runs the binding tags on req and converts failures into a requestError.
+func validateRequest(req interface{}) error {
+	err := binding.Validator.ValidateStruct(req)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return &requestError{message: err.Error()}
+	}
+
+	fields := make(map[string]string, len(validationErrs))
+	for _, fieldErr := range validationErrs {
+		fields[fieldErr.Field()] = validationMessage(fieldErr)
+	}
+	return &requestError{message: "Invalid request", fields: fields}
+}
+
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "gt":
+		return "must be greater than " + fieldErr.Param()
+	case "gte", "min":
+		return "must be at least " + fieldErr.Param()
+	case "lte", "max":
+		return "must be at most " + fieldErr.Param()
+	case "notfuture":
+		return "must not be in the future"
+	case "timezone":
+		return "must be an IANA timezone name such as Europe/London"
+	}
+	return "is invalid"
+}
+
+// ValidateDayValues applies the JSON API's rules to a weight and calorie pair from another source,
+// such as a form or an imported file.
+func ValidateDayValues(weight *float64, calories *float64) error {
+	req := dayValuesRequest{Weight: weight, Calories: calories}
+	if err := validateRequest(&req); err != nil {
+		return err
+	}
+	return requireValues(weight, calories)
+}
+
+// FieldErrors returns the per-field messages of a validation error keyed by JSON field name. Errors
+// that are not about a particular field are keyed by the empty string.
+func FieldErrors(err error) map[string]string {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) && reqErr.fields != nil {
+		return reqErr.fields
+	}
+	return map[string]string{"": err.Error()}
+}
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	regression "git.ebain.es/healthAndFitnessTracker/internal/regression"
+)
+
+// Config holds the settings shared by the web server and its handlers.
+//
+// Values are resolved in increasing order of precedence: built-in defaults, a JSON config file,
+// BULKTRACKER_* environment variables and finally command-line flags.
+type Config struct {
+	DatabasePath           string  `json:"database_path"`
+	ListenAddr             string  `json:"listen_addr"`
+	DateFormat             string  `json:"date_format"`
+	Timezone               string  `json:"timezone"`
+	WeightBandwidth        float64 `json:"weight_bandwidth"`
+	CalorieBandwidth       float64 `json:"calorie_bandwidth"`
+	WeightDeltaBandwidth   float64 `json:"weight_delta_bandwidth"`
+	TDEEWindow             int     `json:"tdee_window"`
+	ExportDateFormat       string  `json:"export_date_format"`
+	ExportDecimalPlaces    int     `json:"export_decimal_places"`
+	WeightAggregation      string  `json:"weight_aggregation"`
+	WeightRobustIterations int     `json:"weight_robust_iterations"`
+	WeightSmoother         string  `json:"weight_smoother"`
+	CalorieSmoother        string  `json:"calorie_smoother"`
+	WeightDeltaSmoother    string  `json:"weight_delta_smoother"`
+	EMASmoothing           float64 `json:"ema_smoothing"`
+	KalmanNoiseRatio       float64 `json:"kalman_noise_ratio"`
+	LOESSDegree            int     `json:"loess_degree"`
+}
+
+// MaxDecimalPlaces is the most decimal places exported values can be rounded to.
+const MaxDecimalPlaces = 6
+
+// Default returns the configuration used when nothing else is specified.
+func Default() *Config {
+	return &Config{
+		DatabasePath:         "fitness.db",
+		ListenAddr:           ":8080",
+		DateFormat:           "02/01/2006",
+		Timezone:             "Local",
+		WeightBandwidth:      0.2,
+		CalorieBandwidth:     0.2,
+		WeightDeltaBandwidth: 0.4,
+		TDEEWindow:           14,
+		ExportDateFormat:     "2006-01-02",
+		ExportDecimalPlaces:  2,
+		WeightAggregation:    string(database.AggregateFirst),
+		WeightSmoother:       string(regression.KindLOESS),
+		CalorieSmoother:      string(regression.KindLOESS),
+		WeightDeltaSmoother:  string(regression.KindLOESS),
+		EMASmoothing:         0.1,
+		KalmanNoiseRatio:     0.01,
+		LOESSDegree:          1,
+	}
+}
+
+// Load registers the configuration flags on fs, parses args and returns the resolved configuration.
+// The config file is taken from the -config flag, falling back to BULKTRACKER_CONFIG.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	var flagCfg Config
+	var configPath string
+
+	fs.StringVar(&configPath, "config", os.Getenv("BULKTRACKER_CONFIG"), "path to a JSON config file")
+	fs.StringVar(&flagCfg.DatabasePath, "db", "", "path to the SQLite database")
+	fs.StringVar(&flagCfg.ListenAddr, "listen", "", "address for the web server to listen on")
+	fs.StringVar(&flagCfg.DateFormat, "date-format", "", "Go layout used to display dates")
+	fs.StringVar(&flagCfg.Timezone, "timezone", "", "IANA timezone used to assign timestamps to calendar days")
+	fs.Float64Var(&flagCfg.WeightBandwidth, "weight-bandwidth", 0, "LOESS bandwidth for weights")
+	fs.Float64Var(&flagCfg.CalorieBandwidth, "calorie-bandwidth", 0, "LOESS bandwidth for calories")
+	fs.Float64Var(&flagCfg.WeightDeltaBandwidth, "weight-delta-bandwidth", 0, "LOESS bandwidth for daily weight change")
+	fs.IntVar(&flagCfg.TDEEWindow, "tdee-window", 0, "number of days averaged when estimating TDEE")
+	fs.IntVar(&flagCfg.WeightRobustIterations, "weight-robust-iterations", 0, "robust LOESS iterations used to discount outlying weigh-ins")
+	fs.StringVar(&flagCfg.WeightSmoother, "weight-smoother", "", "how weights are smoothed: loess, ema or kalman")
+	fs.StringVar(&flagCfg.CalorieSmoother, "calorie-smoother", "", "how calories are smoothed: loess, ema or kalman")
+	fs.StringVar(&flagCfg.WeightDeltaSmoother, "weight-delta-smoother", "", "how daily weight change is smoothed: loess, ema or kalman")
+	fs.Float64Var(&flagCfg.EMASmoothing, "ema-smoothing", 0, "fraction of the way the ema smoother moves towards each day's value")
+	fs.Float64Var(&flagCfg.KalmanNoiseRatio, "kalman-noise-ratio", 0, "daily variance of the kalman smoother's trend relative to the noise in each value")
+	fs.IntVar(&flagCfg.LOESSDegree, "loess-degree", 0, "degree of the local LOESS fits: 1 for lines or 2 for quadratics")
+	fs.StringVar(&flagCfg.ExportDateFormat, "export-date-format", "", "Go layout used for dates in CSV and XLSX exports")
+	fs.IntVar(&flagCfg.ExportDecimalPlaces, "export-decimals", 0, "decimal places values are rounded to in exports")
+	fs.StringVar(&flagCfg.WeightAggregation, "weight-aggregation", "", "how several weigh-ins on a day are combined: first, min, mean or median")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if configPath != "" {
+		if err := cfg.loadFile(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Only flags that were explicitly given override the file and environment.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "db":
+			cfg.DatabasePath = flagCfg.DatabasePath
+		case "listen":
+			cfg.ListenAddr = flagCfg.ListenAddr
+		case "date-format":
+			cfg.DateFormat = flagCfg.DateFormat
+		case "timezone":
+			cfg.Timezone = flagCfg.Timezone
+		case "weight-bandwidth":
+			cfg.WeightBandwidth = flagCfg.WeightBandwidth
+		case "calorie-bandwidth":
+			cfg.CalorieBandwidth = flagCfg.CalorieBandwidth
+		case "weight-delta-bandwidth":
+			cfg.WeightDeltaBandwidth = flagCfg.WeightDeltaBandwidth
+		case "tdee-window":
+			cfg.TDEEWindow = flagCfg.TDEEWindow
+		case "weight-robust-iterations":
+			cfg.WeightRobustIterations = flagCfg.WeightRobustIterations
+		case "weight-smoother":
+			cfg.WeightSmoother = flagCfg.WeightSmoother
+		case "calorie-smoother":
+			cfg.CalorieSmoother = flagCfg.CalorieSmoother
+		case "weight-delta-smoother":
+			cfg.WeightDeltaSmoother = flagCfg.WeightDeltaSmoother
+		case "ema-smoothing":
+			cfg.EMASmoothing = flagCfg.EMASmoothing
+		case "kalman-noise-ratio":
+			cfg.KalmanNoiseRatio = flagCfg.KalmanNoiseRatio
+		case "loess-degree":
+			cfg.LOESSDegree = flagCfg.LOESSDegree
+		case "export-date-format":
+			cfg.ExportDateFormat = flagCfg.ExportDateFormat
+		case "export-decimals":
+			cfg.ExportDecimalPlaces = flagCfg.ExportDecimalPlaces
+		case "weight-aggregation":
+			cfg.WeightAggregation = flagCfg.WeightAggregation
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the configuration values are usable.
+func (cfg *Config) Validate() error {
+	if cfg.DatabasePath == "" {
+		return errors.New("config: the database path must not be empty")
+	}
+	if cfg.DateFormat == "" {
+		return errors.New("config: the date format must not be empty")
+	}
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		return fmt.Errorf("config: invalid timezone: %w", err)
+	}
+	bandwidths := []struct {
+		name  string
+		value float64
+	}{
+		{"weight_bandwidth", cfg.WeightBandwidth},
+		{"calorie_bandwidth", cfg.CalorieBandwidth},
+		{"weight_delta_bandwidth", cfg.WeightDeltaBandwidth},
+	}
+	for _, bandwidth := range bandwidths {
+		if bandwidth.value <= 0 || bandwidth.value > 1 {
+			return fmt.Errorf("config: %s must be >0 and <=1", bandwidth.name)
+		}
+	}
+	if cfg.TDEEWindow < 1 {
+		return errors.New("config: tdee_window must be at least 1")
+	}
+	if cfg.WeightRobustIterations < 0 {
+		return errors.New("config: weight_robust_iterations must not be negative")
+	}
+	if cfg.ExportDateFormat == "" {
+		return errors.New("config: the export date format must not be empty")
+	}
+	if cfg.ExportDecimalPlaces < 0 || cfg.ExportDecimalPlaces > MaxDecimalPlaces {
+		return fmt.Errorf("config: export_decimal_places must be between 0 and %d", MaxDecimalPlaces)
+	}
+	if _, err := database.ParseAggregation(cfg.WeightAggregation); err != nil {
+		return fmt.Errorf("config: invalid weight_aggregation: %w", err)
+	}
+	smoothers := []struct {
+		name  string
+		value string
+	}{
+		{"weight_smoother", cfg.WeightSmoother},
+		{"calorie_smoother", cfg.CalorieSmoother},
+		{"weight_delta_smoother", cfg.WeightDeltaSmoother},
+	}
+	for _, smoother := range smoothers {
+		if _, err := regression.ParseSmootherKind(smoother.value); err != nil {
+			return fmt.Errorf("config: invalid %s: %w", smoother.name, err)
+		}
+	}
+	if cfg.EMASmoothing <= 0 || cfg.EMASmoothing > 1 {
+		return errors.New("config: ema_smoothing must be >0 and <=1")
+	}
+	if cfg.KalmanNoiseRatio <= 0 {
+		return errors.New("config: kalman_noise_ratio must be >0")
+	}
+	if cfg.LOESSDegree != 1 && cfg.LOESSDegree != 2 {
+		return errors.New("config: loess_degree must be 1 or 2")
+	}
+	return nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	if v, ok := os.LookupEnv("BULKTRACKER_DATABASE"); ok {
+		cfg.DatabasePath = v
+	}
+	if v, ok := os.LookupEnv("BULKTRACKER_LISTEN"); ok {
+		cfg.ListenAddr = v
+	}
+	if v, ok := os.LookupEnv("BULKTRACKER_DATE_FORMAT"); ok {
+		cfg.DateFormat = v
+	}
+	if v, ok := os.LookupEnv("BULKTRACKER_TIMEZONE"); ok {
+		cfg.Timezone = v
+	}
+	if v, ok := os.LookupEnv("BULKTRACKER_EXPORT_DATE_FORMAT"); ok {
+		cfg.ExportDateFormat = v
+	}
+	if v, ok := os.LookupEnv("BULKTRACKER_WEIGHT_AGGREGATION"); ok {
+		cfg.WeightAggregation = v
+	}
+	if v, ok := os.LookupEnv("BULKTRACKER_WEIGHT_SMOOTHER"); ok {
+		cfg.WeightSmoother = v
+	}
+	if v, ok := os.LookupEnv("BULKTRACKER_CALORIE_SMOOTHER"); ok {
+		cfg.CalorieSmoother = v
+	}
+	if v, ok := os.LookupEnv("BULKTRACKER_WEIGHT_DELTA_SMOOTHER"); ok {
+		cfg.WeightDeltaSmoother = v
+	}
+
+	floats := map[string]*float64{
+		"BULKTRACKER_WEIGHT_BANDWIDTH":       &cfg.WeightBandwidth,
+		"BULKTRACKER_CALORIE_BANDWIDTH":      &cfg.CalorieBandwidth,
+		"BULKTRACKER_WEIGHT_DELTA_BANDWIDTH": &cfg.WeightDeltaBandwidth,
+		"BULKTRACKER_EMA_SMOOTHING":          &cfg.EMASmoothing,
+		"BULKTRACKER_KALMAN_NOISE_RATIO":     &cfg.KalmanNoiseRatio,
+	}
+	for name, dest := range floats {
+		if v, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("config: %s: %w", name, err)
+			}
+			*dest = parsed
+		}
+	}
+
+	ints := map[string]*int{
+		"BULKTRACKER_TDEE_WINDOW":              &cfg.TDEEWindow,
+		"BULKTRACKER_EXPORT_DECIMALS":          &cfg.ExportDecimalPlaces,
+		"BULKTRACKER_WEIGHT_ROBUST_ITERATIONS": &cfg.WeightRobustIterations,
+		"BULKTRACKER_LOESS_DEGREE":             &cfg.LOESSDegree,
+	}
+	for name, dest := range ints {
+		if v, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("config: %s: %w", name, err)
+			}
+			*dest = parsed
+		}
+	}
+	return nil
+}
+
+// Aggregation returns the configured weight aggregation. Validate has already checked that it parses.
+func (cfg *Config) Aggregation() database.Aggregation {
+	return database.Aggregation(cfg.WeightAggregation)
+}
+
+// Location returns the configured default timezone. Validate has already checked that it loads.
+func (cfg *Config) Location() *time.Location {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
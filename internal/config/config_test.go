@@ -0,0 +1,77 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	contents := `{"database_path": "file.db", "listen_addr": ":9000", "tdee_window": 10}`
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("BULKTRACKER_LISTEN", ":9001")
+	defer os.Unsetenv("BULKTRACKER_LISTEN")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Load(fs, []string{"-config", path, "-tdee-window", "21"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DatabasePath != "file.db" {
+		t.Errorf("DatabasePath was %v not file.db", cfg.DatabasePath)
+	}
+	if cfg.ListenAddr != ":9001" {
+		t.Errorf("ListenAddr was %v not :9001", cfg.ListenAddr)
+	}
+	if cfg.TDEEWindow != 21 {
+		t.Errorf("TDEEWindow was %v not 21", cfg.TDEEWindow)
+	}
+	if cfg.WeightBandwidth != Default().WeightBandwidth {
+		t.Errorf("WeightBandwidth was %v not the default", cfg.WeightBandwidth)
+	}
+}
+
+func TestLoadRejectsBadBandwidth(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := Load(fs, []string{"-weight-bandwidth", "1.5"})
+	if err == nil {
+		t.Error("Load accepted a bandwidth above 1")
+	}
+}
+
+func TestLoadRejectsBadAggregation(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := Load(fs, []string{"-weight-aggregation", "max"})
+	if err == nil {
+		t.Error("Load accepted an unknown weight aggregation")
+	}
+}
+
+func TestLoadRejectsBadSmoother(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := Load(fs, []string{"-calorie-smoother", "spline"})
+	if err == nil {
+		t.Error("Load accepted an unknown smoother")
+	}
+}
+
+func TestLoadRejectsBadLOESSDegree(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := Load(fs, []string{"-loess-degree", "3"})
+	if err == nil {
+		t.Error("Load accepted a LOESS degree of 3")
+	}
+}
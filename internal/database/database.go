@@ -2,76 +2,143 @@ package database
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
-type dayRecord struct {
+// DayRecord is a single day's logged weight and calories.
+type DayRecord struct {
+	ID       int64
 	Time     time.Time
 	Weight   sql.NullFloat64
 	Calories sql.NullFloat64
 }
 
-const dateFormat = "02/01/2006"
+// DateFormat is the layout dates are stored in, chosen so that text ordering matches date ordering.
+const DateFormat = "2006-01-02"
 
-func GetFinalRows(dbConn *sql.DB, numRows int) []dayRecord {
+// GetFinalRows returns up to numRows of the user's most recent day records in date order. The weight
+// of days with weigh-in measurements is their aggregate by agg.
+func GetFinalRows(dbConn *sql.DB, userID int64, numRows int, agg Aggregation) ([]DayRecord, error) {
 	sqlCntStmt :=
-		"SELECT COUNT(date) FROM weight ORDER BY id DESC LIMIT " + strconv.Itoa(numRows) + ";"
+		"SELECT COUNT(date) FROM weight WHERE user_id = ? ORDER BY date DESC LIMIT " + strconv.Itoa(numRows) + ";"
 	sqlStmt :=
-		"SELECT date, weight_kg, calories_kcal FROM weight ORDER BY id DESC LIMIT " + strconv.Itoa(numRows) + ";"
+		"SELECT id, date, weight_kg, calories_kcal FROM weight WHERE user_id = ? ORDER BY date DESC LIMIT " + strconv.Itoa(numRows) + ";"
 
-	rows, err := dbConn.Query(sqlCntStmt)
+	var count int
+	err := dbConn.QueryRow(sqlCntStmt, userID).Scan(&count)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("database: counting rows: %w", err)
+	}
+
+	if count > numRows {
+		count = numRows
+	}
+
+	rows, err := dbConn.Query(sqlStmt, userID)
+	if err != nil {
+		return nil, fmt.Errorf("database: querying rows: %w", err)
 	}
 	defer rows.Close()
 
-	var count int
-	for rows.Next() {
-		err = rows.Scan(&count)
-		if err != nil {
-			log.Fatal(err)
-		}
+	recordSlice, err := scanDayRows(rows, count)
+	if err != nil {
+		return nil, err
 	}
 
-	if count > numRows {
-		count = numRows
+	for i, j := 0, len(recordSlice)-1; i < j; i, j = i+1, j-1 {
+		recordSlice[i], recordSlice[j] = recordSlice[j], recordSlice[i]
 	}
 
-	rows, err = dbConn.Query(sqlStmt)
+	if err := applyMeasurements(dbConn, userID, recordSlice, agg); err != nil {
+		return nil, err
+	}
+	return recordSlice, nil
+}
+
+// DayQuery selects day records in ascending date order. Zero times leave that end of the range open.
+type DayQuery struct {
+	From  time.Time
+	To    time.Time
+	After time.Time
+	Limit int
+}
+
+// QueryDays returns the user's day records between q.From and q.To inclusive that fall strictly after
+// q.After, ordered by date and limited to q.Limit rows when it is positive. The weight of days with
+// weigh-in measurements is their aggregate by agg.
+func QueryDays(dbConn *sql.DB, userID int64, q DayQuery, agg Aggregation) ([]DayRecord, error) {
+	conditions := []string{"user_id = ?"}
+	args := []interface{}{userID}
+
+	if !q.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, q.From.Format(DateFormat))
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, q.To.Format(DateFormat))
+	}
+	if !q.After.IsZero() {
+		conditions = append(conditions, "date > ?")
+		args = append(args, q.After.Format(DateFormat))
+	}
+
+	sqlStmt := "SELECT id, date, weight_kg, calories_kcal FROM weight WHERE " +
+		strings.Join(conditions, " AND ") + " ORDER BY date ASC"
+
+	capacity := 0
+	if q.Limit > 0 {
+		sqlStmt += " LIMIT ?"
+		args = append(args, q.Limit)
+		capacity = q.Limit
+	}
+
+	rows, err := dbConn.Query(sqlStmt, args...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("database: querying rows: %w", err)
 	}
 	defer rows.Close()
 
-	recordSlice := make([]dayRecord, 0, count)
+	recordSlice, err := scanDayRows(rows, capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyMeasurements(dbConn, userID, recordSlice, agg); err != nil {
+		return nil, err
+	}
+	return recordSlice, nil
+}
+
+func scanDayRows(rows *sql.Rows, capacity int) ([]DayRecord, error) {
+	recordSlice := make([]DayRecord, 0, capacity)
 
 	var date string
-	var entry dayRecord
+	var entry DayRecord
 
 	for rows.Next() {
 
-		err = rows.Scan(&date, &entry.Weight, &entry.Calories)
+		err := rows.Scan(&entry.ID, &date, &entry.Weight, &entry.Calories)
 
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("database: reading row: %w", err)
 		}
 
-		parsedDate, _ := time.Parse(dateFormat, date)
+		parsedDate, err := time.Parse(DateFormat, date)
+		if err != nil {
+			return nil, fmt.Errorf("database: row %d has invalid date %q: %w", entry.ID, date, err)
+		}
 		entry.Time = parsedDate
 		recordSlice = append(recordSlice, entry)
 
 	}
 
-	err = rows.Err()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	for i, j := 0, len(recordSlice)-1; i < j; i, j = i+1, j-1 {
-		recordSlice[i], recordSlice[j] = recordSlice[j], recordSlice[i]
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database: reading rows: %w", err)
 	}
 
-	return recordSlice
+	return recordSlice, nil
 }
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ConflictMode decides what ImportDays does with a record for a date the user already has.
+type ConflictMode string
+
+const (
+	// ConflictSkip keeps the existing record.
+	ConflictSkip ConflictMode = "skip"
+	// ConflictOverwrite replaces the existing record's values, including clearing values the import lacks.
+	ConflictOverwrite ConflictMode = "overwrite"
+	// ConflictMerge fills in the imported values and keeps existing values the import lacks.
+	ConflictMerge ConflictMode = "merge"
+)
+
+// ParseConflictMode returns the conflict mode named s.
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch mode := ConflictMode(s); mode {
+	case ConflictSkip, ConflictOverwrite, ConflictMerge:
+		return mode, nil
+	}
+	return "", fmt.Errorf("database: unknown conflict mode %q, expected skip, overwrite or merge", s)
+}
+
+// ImportConflict describes an imported record whose date already had a record.
+type ImportConflict struct {
+	// Index is the position of the imported record in the slice passed to ImportDays.
+	Index int
+	// Existing is the record that was stored before the import wrote it.
+	Existing DayRecord
+	// Measured is set when the existing day's weight comes from its weigh-ins and the imported weight
+	// differs from it, which stops the import from being written.
+	Measured bool
+}
+
+// ImportResult counts what ImportDays did with each record.
+type ImportResult struct {
+	Inserted  int
+	Updated   int
+	Skipped   int
+	Conflicts []ImportConflict
+}
+
+// ImportDays writes records for the user in a single transaction, resolving records for dates that
+// already exist, including earlier records in the same import, according to mode. With dryRun, or if any
+// conflict is Measured, the transaction is rolled back, so the result reports what the import would do
+// without changing anything.
+func (s *Store) ImportDays(userID int64, records []DayRecord, mode ConflictMode, dryRun bool) (*ImportResult, error) {
+	if _, err := ParseConflictMode(string(mode)); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var result ImportResult
+	var measured bool
+	for i, record := range records {
+		date := record.Time.Format(DateFormat)
+
+		_, err := tx.Exec("INSERT INTO weight(user_id, date, weight_kg, calories_kcal) VALUES (?, ?, ?, ?)",
+			userID, date, record.Weight, record.Calories)
+		if err == nil {
+			result.Inserted++
+			continue
+		} else if err = translateErr(err); !errors.Is(err, ErrDuplicateDay) {
+			return nil, fmt.Errorf("database: importing %s: %w", date, err)
+		}
+
+		existing := DayRecord{Time: record.Time}
+		err = tx.QueryRow("SELECT id, weight_kg, calories_kcal FROM weight WHERE user_id=? AND date=?", userID, date).
+			Scan(&existing.ID, &existing.Weight, &existing.Calories)
+		if err != nil {
+			return nil, fmt.Errorf("database: importing %s: %w", date, err)
+		}
+		conflict := ImportConflict{Index: i, Existing: existing}
+		if mode != ConflictSkip {
+			err = checkMeasuredWeight(tx, existing.ID, record.Weight, s.aggregation)
+			if errors.Is(err, ErrMeasuredWeight) {
+				conflict.Measured = true
+				measured = true
+			} else if err != nil {
+				return nil, fmt.Errorf("database: importing %s: %w", date, err)
+			}
+		}
+		result.Conflicts = append(result.Conflicts, conflict)
+
+		switch mode {
+		case ConflictSkip:
+			result.Skipped++
+			continue
+		case ConflictOverwrite:
+			_, err = tx.Exec("UPDATE weight SET weight_kg=?, calories_kcal=? WHERE id=?",
+				record.Weight, record.Calories, existing.ID)
+		case ConflictMerge:
+			_, err = tx.Exec("UPDATE weight SET weight_kg=COALESCE(?, weight_kg), calories_kcal=COALESCE(?, calories_kcal) WHERE id=?",
+				record.Weight, record.Calories, existing.ID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("database: importing %s: %w", date, err)
+		}
+		result.Updated++
+	}
+
+	if dryRun || measured {
+		return &result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ErrMeasuredWeight is returned when a weight is written for a day with weigh-ins that differs from
+// their aggregate. The day's weight comes from its weigh-ins, so the written weight would never be seen.
+var ErrMeasuredWeight = errors.New("database: the day's weight comes from its weigh-ins")
+
+// Aggregation is how the weigh-ins of a day are combined into the day's weight.
+type Aggregation string
+
+const (
+	// AggregateFirst uses the earliest weigh-in, which is normally the morning weight before eating.
+	AggregateFirst Aggregation = "first"
+	// AggregateMin uses the lowest weigh-in.
+	AggregateMin Aggregation = "min"
+	// AggregateMean averages the weigh-ins.
+	AggregateMean Aggregation = "mean"
+	// AggregateMedian uses the middle weigh-in, averaging the two middle ones when there is an even number.
+	AggregateMedian Aggregation = "median"
+)
+
+// ParseAggregation returns the aggregation named s.
+func ParseAggregation(s string) (Aggregation, error) {
+	switch agg := Aggregation(s); agg {
+	case AggregateFirst, AggregateMin, AggregateMean, AggregateMedian:
+		return agg, nil
+	}
+	return "", fmt.Errorf("database: unknown aggregation %q, expected first, min, mean or median", s)
+}
+
+// apply combines weights, which are in the order they were measured and must not be empty.
+func (agg Aggregation) apply(weights []float64) float64 {
+	switch agg {
+	case AggregateMin:
+		min := weights[0]
+		for _, weight := range weights[1:] {
+			if weight < min {
+				min = weight
+			}
+		}
+		return min
+	case AggregateMean:
+		var sum float64
+		for _, weight := range weights {
+			sum += weight
+		}
+		return sum / float64(len(weights))
+	case AggregateMedian:
+		sorted := append([]float64(nil), weights...)
+		sort.Float64s(sorted)
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[middle-1] + sorted[middle]) / 2
+		}
+		return sorted[middle]
+	}
+	return weights[0]
+}
+
+// Measurement is a single timestamped weigh-in. A day can have several, which replace the weight
+// logged for the day when it is read.
+type Measurement struct {
+	ID     int64
+	DayID  int64
+	Time   time.Time
+	Weight float64
+}
+
+// applyMeasurements replaces the weight of each of the user's records that has weigh-ins with their
+// aggregate. The records must be in date order.
+func applyMeasurements(dbConn *sql.DB, userID int64, records []DayRecord, agg Aggregation) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	rows, err := dbConn.Query(`SELECT m.day_id, m.weight_kg FROM measurements m
+			JOIN weight w ON w.id = m.day_id
+		WHERE w.user_id = ? AND w.date BETWEEN ? AND ?
+		ORDER BY m.day_id, m.measured_at, m.id`,
+		userID, records[0].Time.Format(DateFormat), records[len(records)-1].Time.Format(DateFormat))
+	if err != nil {
+		return fmt.Errorf("database: querying measurements: %w", err)
+	}
+	defer rows.Close()
+
+	weights := map[int64][]float64{}
+	for rows.Next() {
+		var dayID int64
+		var weight float64
+		if err := rows.Scan(&dayID, &weight); err != nil {
+			return fmt.Errorf("database: reading measurement: %w", err)
+		}
+		weights[dayID] = append(weights[dayID], weight)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("database: reading measurements: %w", err)
+	}
+
+	for i := range records {
+		if dayWeights, ok := weights[records[i].ID]; ok {
+			records[i].Weight = sql.NullFloat64{Float64: agg.apply(dayWeights), Valid: true}
+		}
+	}
+	return nil
+}
+
+// queryer is the part of *sql.DB and *sql.Tx that checkMeasuredWeight needs.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// checkMeasuredWeight returns ErrMeasuredWeight if weight is set and the day with the given id has weigh-ins
+// whose aggregate differs from it at the 0.1kg weights are shown with, so resubmitting the weight a day is
+// shown with succeeds.
+func checkMeasuredWeight(q queryer, dayID int64, weight sql.NullFloat64, agg Aggregation) error {
+	if !weight.Valid {
+		return nil
+	}
+
+	rows, err := q.Query("SELECT weight_kg FROM measurements WHERE day_id=? ORDER BY measured_at, id", dayID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var weights []float64
+	for rows.Next() {
+		var w float64
+		if err := rows.Scan(&w); err != nil {
+			return err
+		}
+		weights = append(weights, w)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(weights) > 0 && math.Round(agg.apply(weights)*10) != math.Round(weight.Float64*10) {
+		return ErrMeasuredWeight
+	}
+	return nil
+}
+
+// AddMeasurement records a weigh-in for the user on date, creating the day's record if it has none,
+// and returns the stored measurement.
+func (s *Store) AddMeasurement(userID int64, date time.Time, measuredAt time.Time, weight float64) (Measurement, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Measurement{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO weight(user_id, date) VALUES (?, ?) ON CONFLICT(user_id, date) DO NOTHING",
+		userID, date.Format(DateFormat))
+	if err != nil {
+		return Measurement{}, err
+	}
+
+	m := Measurement{Time: measuredAt, Weight: weight}
+	err = tx.QueryRow("SELECT id FROM weight WHERE user_id=? AND date=?", userID, date.Format(DateFormat)).Scan(&m.DayID)
+	if err != nil {
+		return Measurement{}, err
+	}
+
+	result, err := tx.Exec("INSERT INTO measurements(day_id, measured_at, weight_kg) VALUES (?, ?, ?)",
+		m.DayID, measuredAt.Unix(), weight)
+	if err != nil {
+		return Measurement{}, err
+	}
+	if m.ID, err = result.LastInsertId(); err != nil {
+		return Measurement{}, err
+	}
+
+	return m, tx.Commit()
+}
+
+// ListMeasurements returns the weigh-ins of the user's day record with the given id in the order they
+// were measured, or ErrNotFound if the user has no such day.
+func (s *Store) ListMeasurements(userID int64, dayID int64) ([]Measurement, error) {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM weight WHERE id=? AND user_id=?)", dayID, userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := s.db.Query("SELECT id, measured_at, weight_kg FROM measurements WHERE day_id=? ORDER BY measured_at, id", dayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	measurements := []Measurement{}
+	for rows.Next() {
+		m := Measurement{DayID: dayID}
+		var measuredAt int64
+		if err := rows.Scan(&m.ID, &measuredAt, &m.Weight); err != nil {
+			return nil, err
+		}
+		m.Time = time.Unix(measuredAt, 0).UTC()
+		measurements = append(measurements, m)
+	}
+	return measurements, rows.Err()
+}
+
+// DeleteMeasurement removes one of the user's weigh-ins. The day keeps the weight logged for it, if any.
+func (s *Store) DeleteMeasurement(userID int64, id int64) error {
+	result, err := s.db.Exec(`DELETE FROM measurements WHERE id=?
+		AND day_id IN (SELECT id FROM weight WHERE user_id=?)`, id, userID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
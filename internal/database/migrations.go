@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations are applied in order and must never be edited once released; add a new entry instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create weight table",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS weight (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				date TEXT NOT NULL UNIQUE,
+				weight_kg REAL,
+				calories_kcal INTEGER
+			)`,
+		},
+	},
+	{
+		version:     2,
+		description: "store dates as ISO-8601",
+		statements: []string{
+			// Rewrite the legacy DD/MM/YYYY dates so that they sort chronologically as text.
+			`UPDATE weight
+				SET date = substr(date, 7, 4) || '-' || substr(date, 4, 2) || '-' || substr(date, 1, 2)
+				WHERE date LIKE '__/__/____'`,
+		},
+	},
+	{
+		version:     3,
+		description: "add users and scope day records to a user",
+		statements: []string{
+			`CREATE TABLE users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL,
+				timezone TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			// Existing single-user data is given to an "owner" account, which cannot log in until a
+			// password is set with the user passwd command.
+			`INSERT INTO users(id, username, password_hash)
+				SELECT 1, 'owner', '' WHERE EXISTS (SELECT 1 FROM weight)`,
+			`CREATE TABLE weight_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				date TEXT NOT NULL,
+				weight_kg REAL,
+				calories_kcal INTEGER,
+				UNIQUE (user_id, date)
+			)`,
+			`INSERT INTO weight_new(id, user_id, date, weight_kg, calories_kcal)
+				SELECT id, 1, date, weight_kg, calories_kcal FROM weight`,
+			`DROP TABLE weight`,
+			`ALTER TABLE weight_new RENAME TO weight`,
+		},
+	},
+	{
+		version:     4,
+		description: "add API tokens",
+		statements: []string{
+			`CREATE TABLE tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT NOT NULL,
+				created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				last_used_at TEXT,
+				revoked_at TEXT
+			)`,
+		},
+	},
+	{
+		version:     5,
+		description: "add login sessions",
+		statements: []string{
+			`CREATE TABLE sessions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				token_hash TEXT NOT NULL UNIQUE,
+				csrf_token TEXT NOT NULL,
+				created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				expires_at INTEGER NOT NULL
+			)`,
+		},
+	},
+	{
+		version:     6,
+		description: "add weigh-in measurements",
+		statements: []string{
+			`CREATE TABLE measurements (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				day_id INTEGER NOT NULL REFERENCES weight(id) ON DELETE CASCADE,
+				measured_at INTEGER NOT NULL,
+				weight_kg REAL NOT NULL
+			)`,
+			`CREATE INDEX measurements_day ON measurements(day_id, measured_at)`,
+		},
+	},
+}
+
+// Migrate creates the schema version table if needed and applies any migrations newer than the
+// recorded version, each in its own transaction.
+func Migrate(dbConn *sql.DB) error {
+	return migrateTo(dbConn, migrations[len(migrations)-1].version)
+}
+
+func migrateTo(dbConn *sql.DB, target int) error {
+	_, err := dbConn.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER NOT NULL PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("migrate: creating schema_version: %w", err)
+	}
+
+	current, err := SchemaVersion(dbConn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		if err := applyMigration(dbConn, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the most recently applied migration version, or 0 for an empty database.
+func SchemaVersion(dbConn *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := dbConn.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("migrate: reading schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func applyMigration(dbConn *sql.DB, m migration) error {
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrate: version %d (%s): %w", m.version, m.description, err)
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_version(version, description) VALUES (?, ?)", m.version, m.description)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("migrate: recording version %d: %w", m.version, err)
+	}
+
+	return tx.Commit()
+}
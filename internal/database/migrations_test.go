@@ -0,0 +1,100 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openMemoryDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+	db := openMemoryDB(t)
+	defer db.Close()
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrations[len(migrations)-1].version {
+		t.Errorf("SchemaVersion returned %v not %v", version, migrations[len(migrations)-1].version)
+	}
+
+	_, err = db.Exec("INSERT INTO users(username, password_hash) VALUES ('alice', '')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO weight(user_id, date, weight_kg, calories_kcal) VALUES (1, '2021-01-01', 80.1, 2500)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Running again must be a no-op.
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("INSERT INTO weight(user_id, date, weight_kg, calories_kcal) VALUES (1, '2021-01-01', 80.2, 2400)")
+	if err == nil {
+		t.Error("weight table accepted a duplicate date")
+	}
+}
+
+func TestMigrateLegacyDates(t *testing.T) {
+	db := openMemoryDB(t)
+	defer db.Close()
+
+	if err := migrateTo(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec("INSERT INTO weight(date, weight_kg) VALUES ('25/12/2020', 80.1), ('02/01/2021', 80.5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var owner string
+	if err := db.QueryRow("SELECT u.username FROM weight w JOIN users u ON u.id = w.user_id LIMIT 1").Scan(&owner); err != nil {
+		t.Fatal(err)
+	}
+	if owner != "owner" {
+		t.Errorf("Legacy rows were given to %v not owner", owner)
+	}
+
+	rows, err := db.Query("SELECT date FROM weight ORDER BY date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	correct := []string{"2020-12-25", "2021-01-02"}
+	var i int
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			t.Fatal(err)
+		}
+		if date != correct[i] {
+			t.Errorf("Migrated date was %v not %v", date, correct[i])
+		}
+		i++
+	}
+	if i != len(correct) {
+		t.Errorf("Found %v rows not %v", i, len(correct))
+	}
+}
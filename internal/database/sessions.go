@@ -0,0 +1,68 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Session is a browser login. CSRFToken must accompany every form submission made with the session.
+type Session struct {
+	ID        int64
+	UserID    int64
+	CSRFToken string
+	ExpiresAt time.Time
+}
+
+// CreateSession starts a login session for the user lasting ttl and returns the cookie value, which
+// is stored only as a hash. Expired sessions are cleared out at the same time.
+func (s *Store) CreateSession(userID int64, ttl time.Duration) (string, Session, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return "", Session{}, err
+	}
+	csrfToken, err := randomString()
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().Unix()); err != nil {
+		return "", Session{}, err
+	}
+
+	session := Session{UserID: userID, CSRFToken: csrfToken, ExpiresAt: time.Now().Add(ttl)}
+	result, err := s.db.Exec("INSERT INTO sessions(user_id, token_hash, csrf_token, expires_at) VALUES (?, ?, ?, ?)",
+		userID, hashToken(plaintext), csrfToken, session.ExpiresAt.Unix())
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	session.ID, err = result.LastInsertId()
+	return plaintext, session, err
+}
+
+// GetSession returns the unexpired session for a cookie value and its user, or ErrInvalidCredentials.
+func (s *Store) GetSession(plaintext string) (User, Session, error) {
+	var user User
+	var session Session
+	var expiresAt int64
+
+	err := s.db.QueryRow(`SELECT s.id, s.user_id, s.csrf_token, s.expires_at, u.id, u.username, u.timezone
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`, hashToken(plaintext), time.Now().Unix()).
+		Scan(&session.ID, &session.UserID, &session.CSRFToken, &expiresAt, &user.ID, &user.Username, &user.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, Session{}, ErrInvalidCredentials
+	} else if err != nil {
+		return User{}, Session{}, err
+	}
+
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	return user, session, nil
+}
+
+// DeleteSession ends the session for a cookie value. Unknown sessions are ignored.
+func (s *Store) DeleteSession(plaintext string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(plaintext))
+	return err
+}
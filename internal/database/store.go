@@ -0,0 +1,249 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrDuplicateDay is returned when a record is written for a date that already has one.
+var ErrDuplicateDay = errors.New("database: day already exists")
+
+// ErrNotFound is returned when no record has the requested id, or it belongs to another user.
+var ErrNotFound = errors.New("database: record not found")
+
+// MemoryPath opens a private in-memory database, which is useful for tests.
+const MemoryPath = ":memory:"
+
+// Store owns the connection pool for the SQLite database and exposes typed access to day records.
+// It is safe for concurrent use and is intended to be created once and shared by all handlers.
+type Store struct {
+	db          *sql.DB
+	aggregation Aggregation
+}
+
+// Open opens the database at path, configures the connection pool and brings the schema up to date.
+func Open(path string) (*Store, error) {
+	var db *sql.DB
+	var err error
+
+	if path == MemoryPath {
+		// Every connection to :memory: gets its own database, so keep to a single connection.
+		db, err = sql.Open("sqlite3", "file::memory:?_foreign_keys=on")
+		if err != nil {
+			return nil, err
+		}
+		db.SetMaxOpenConns(1)
+	} else {
+		db, err = sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on")
+		if err != nil {
+			return nil, err
+		}
+		// WAL mode allows readers alongside the single writer; the busy timeout covers writer contention.
+		db.SetMaxOpenConns(8)
+		db.SetMaxIdleConns(4)
+		db.SetConnMaxIdleTime(5 * time.Minute)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db, aggregation: AggregateFirst}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SetWeightAggregation sets how days with several weigh-ins are given a single weight, which defaults
+// to the first weigh-in. It must be called before the store is shared.
+func (s *Store) SetWeightAggregation(agg Aggregation) {
+	s.aggregation = agg
+}
+
+// CreateDay inserts a new day record for the user and returns its id.
+func (s *Store) CreateDay(userID int64, record DayRecord) (int64, error) {
+	result, err := s.db.Exec("INSERT INTO weight(user_id, date, weight_kg, calories_kcal) VALUES (?, ?, ?, ?)",
+		userID, record.Time.Format(DateFormat), record.Weight, record.Calories)
+	if err != nil {
+		return 0, translateErr(err)
+	}
+	return result.LastInsertId()
+}
+
+// UpdateDay replaces every field of the user's day record with the given id. It returns ErrMeasuredWeight
+// if the day has weigh-ins and the weight differs from theirs.
+func (s *Store) UpdateDay(userID int64, record DayRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkMeasuredWeight(tx, record.ID, record.Weight, s.aggregation); err != nil {
+		return err
+	}
+	result, err := tx.Exec("UPDATE weight SET date=?, weight_kg=?, calories_kcal=? WHERE id = ? AND user_id = ?",
+		record.Time.Format(DateFormat), record.Weight, record.Calories, record.ID, userID)
+	if err != nil {
+		return translateErr(err)
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PatchDay updates only the fields that are set on record: a zero Time and invalid weight or calories
+// leave the stored values untouched. It returns ErrMeasuredWeight if the day has weigh-ins and the weight
+// differs from theirs.
+func (s *Store) PatchDay(userID int64, record DayRecord) error {
+	var date sql.NullString
+	if !record.Time.IsZero() {
+		date = sql.NullString{String: record.Time.Format(DateFormat), Valid: true}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkMeasuredWeight(tx, record.ID, record.Weight, s.aggregation); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE weight SET
+			date = COALESCE(?, date),
+			weight_kg = COALESCE(?, weight_kg),
+			calories_kcal = COALESCE(?, calories_kcal)
+		WHERE id = ? AND user_id = ?`,
+		date, record.Weight, record.Calories, record.ID, userID)
+	if err != nil {
+		return translateErr(err)
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpsertDay creates the user's record for record.Time's date or merges the valid fields of record into
+// the existing one, returning the stored result. It returns ErrMeasuredWeight if the existing day has
+// weigh-ins and the weight differs from theirs.
+func (s *Store) UpsertDay(userID int64, record DayRecord) (DayRecord, error) {
+	date := record.Time.Format(DateFormat)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return DayRecord{}, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow("SELECT id FROM weight WHERE user_id=? AND date=?", userID, date).Scan(&id)
+	if err == nil {
+		err = checkMeasuredWeight(tx, id, record.Weight, s.aggregation)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	if err != nil {
+		return DayRecord{}, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO weight(user_id, date, weight_kg, calories_kcal) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, date) DO UPDATE SET
+			weight_kg = COALESCE(excluded.weight_kg, weight_kg),
+			calories_kcal = COALESCE(excluded.calories_kcal, calories_kcal)`,
+		userID, date, record.Weight, record.Calories)
+	if err != nil {
+		return DayRecord{}, translateErr(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return DayRecord{}, err
+	}
+
+	return s.GetDayByDate(userID, record.Time)
+}
+
+func (s *Store) DeleteDay(userID int64, id int64) error {
+	result, err := s.db.Exec("DELETE FROM weight WHERE id=? AND user_id=?", id, userID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// GetDay returns the user's day record with the given id, or ErrNotFound if there is none.
+func (s *Store) GetDay(userID int64, id int64) (DayRecord, error) {
+	var record DayRecord
+	var date string
+
+	err := s.db.QueryRow("SELECT id, date, weight_kg, calories_kcal FROM weight WHERE id=? AND user_id=?", id, userID).
+		Scan(&record.ID, &date, &record.Weight, &record.Calories)
+	if errors.Is(err, sql.ErrNoRows) {
+		return record, ErrNotFound
+	} else if err != nil {
+		return record, err
+	}
+
+	record.Time, err = time.Parse(DateFormat, date)
+	if err != nil {
+		return record, err
+	}
+
+	records := []DayRecord{record}
+	if err := applyMeasurements(s.db, userID, records, s.aggregation); err != nil {
+		return record, err
+	}
+	return records[0], nil
+}
+
+// GetDayByDate returns the user's day record for date, or ErrNotFound if there is none.
+func (s *Store) GetDayByDate(userID int64, date time.Time) (DayRecord, error) {
+	var id int64
+	err := s.db.QueryRow("SELECT id FROM weight WHERE user_id=? AND date=?", userID, date.Format(DateFormat)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DayRecord{}, ErrNotFound
+	} else if err != nil {
+		return DayRecord{}, err
+	}
+	return s.GetDay(userID, id)
+}
+
+// ListDays returns up to numRows of the user's most recent day records in date order.
+func (s *Store) ListDays(userID int64, numRows int) ([]DayRecord, error) {
+	return GetFinalRows(s.db, userID, numRows, s.aggregation)
+}
+
+// QueryDays returns the user's day records selected by q in date order.
+func (s *Store) QueryDays(userID int64, q DayQuery) ([]DayRecord, error) {
+	return QueryDays(s.db, userID, q, s.aggregation)
+}
+
+func checkAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func translateErr(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrDuplicateDay
+	}
+	return err
+}
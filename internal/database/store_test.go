@@ -0,0 +1,534 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openMemoryStore(t *testing.T) *Store {
+	store, err := Open(MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func createTestUser(t *testing.T, store *Store, username string) int64 {
+	user, err := store.CreateUser(username, "correct horse", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+func TestStoreDayLifecycle(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	userID := createTestUser(t, store, "alice")
+
+	record := DayRecord{
+		Time:     time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		Weight:   sql.NullFloat64{Float64: 80.4, Valid: true},
+		Calories: sql.NullFloat64{Float64: 2600, Valid: true},
+	}
+
+	id, err := store.CreateDay(userID, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.CreateDay(userID, record); !errors.Is(err, ErrDuplicateDay) {
+		t.Errorf("CreateDay returned %v not ErrDuplicateDay", err)
+	}
+
+	record.ID = id
+	record.Weight.Float64 = 80.1
+	if err := store.UpdateDay(userID, record); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetDay(userID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != record {
+		t.Errorf("GetDay returned %+v not %+v", got, record)
+	}
+
+	if err := store.DeleteDay(userID, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetDay(userID, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetDay after delete returned %v not ErrNotFound", err)
+	}
+	if err := store.DeleteDay(userID, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteDay after delete returned %v not ErrNotFound", err)
+	}
+	if err := store.UpdateDay(userID, record); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateDay after delete returned %v not ErrNotFound", err)
+	}
+}
+
+func TestStoreListDaysInDateOrder(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	userID := createTestUser(t, store, "alice")
+
+	// Backfilled days are inserted after later ones but must still be listed chronologically.
+	for _, day := range []int{3, 1, 2} {
+		_, err := store.CreateDay(userID, DayRecord{
+			Time:   time.Date(2021, 3, day, 0, 0, 0, 0, time.UTC),
+			Weight: sql.NullFloat64{Float64: 80 + float64(day), Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := store.ListDays(userID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("ListDays returned %v records not 2", len(records))
+	}
+	if records[0].Time.Day() != 2 || records[1].Time.Day() != 3 {
+		t.Errorf("ListDays returned days %v and %v not 2 and 3", records[0].Time.Day(), records[1].Time.Day())
+	}
+}
+
+func TestListDaysReportsBadDate(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	userID := createTestUser(t, store, "alice")
+
+	_, err := store.db.Exec("INSERT INTO weight(id, user_id, date, weight_kg) VALUES (7, ?, 'yesterday', 80)", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.ListDays(userID, 10)
+	if err == nil {
+		t.Fatal("ListDays accepted an invalid date")
+	}
+	if !strings.Contains(err.Error(), "row 7") {
+		t.Errorf("ListDays error %q does not identify the row", err)
+	}
+}
+
+func TestQueryDaysRangeAndCursor(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	userID := createTestUser(t, store, "alice")
+
+	for day := 1; day <= 10; day++ {
+		_, err := store.CreateDay(userID, DayRecord{
+			Time:   time.Date(2021, 3, day, 0, 0, 0, 0, time.UTC),
+			Weight: sql.NullFloat64{Float64: 80, Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := store.QueryDays(userID, DayQuery{
+		From:  time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC),
+		To:    time.Date(2021, 3, 8, 0, 0, 0, 0, time.UTC),
+		After: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+		Limit: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	correct := []int{5, 6, 7}
+	if len(records) != len(correct) {
+		t.Fatalf("QueryDays returned %v records not %v", len(records), len(correct))
+	}
+	for i, record := range records {
+		if record.Time.Day() != correct[i] {
+			t.Errorf("QueryDays returned day %v not %v", record.Time.Day(), correct[i])
+		}
+	}
+}
+
+func TestStorePatchAndUpsertMergeFields(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	userID := createTestUser(t, store, "alice")
+
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Morning weigh-in followed by the evening calorie total from another device.
+	morning, err := store.UpsertDay(userID, DayRecord{Time: day, Weight: sql.NullFloat64{Float64: 80.2, Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	evening, err := store.UpsertDay(userID, DayRecord{Time: day, Calories: sql.NullFloat64{Float64: 2700, Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evening.ID != morning.ID || evening.Weight.Float64 != 80.2 || evening.Calories.Float64 != 2700 {
+		t.Errorf("UpsertDay returned %+v after merging into %+v", evening, morning)
+	}
+
+	err = store.PatchDay(userID, DayRecord{ID: morning.ID, Weight: sql.NullFloat64{Float64: 80.0, Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := store.GetDay(userID, morning.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Weight.Float64 != 80.0 || patched.Calories.Float64 != 2700 || !patched.Time.Equal(day) {
+		t.Errorf("PatchDay left %+v", patched)
+	}
+
+	if err := store.PatchDay(userID, DayRecord{ID: morning.ID + 1}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("PatchDay on a missing id returned %v not ErrNotFound", err)
+	}
+}
+
+func TestStoreIsolatesUsers(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	alice := createTestUser(t, store, "alice")
+	bob := createTestUser(t, store, "bob")
+
+	day := DayRecord{
+		Time:   time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		Weight: sql.NullFloat64{Float64: 80, Valid: true},
+	}
+
+	id, err := store.CreateDay(alice, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same date is free for another user.
+	if _, err := store.CreateDay(bob, day); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetDay(bob, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetDay for another user's record returned %v not ErrNotFound", err)
+	}
+	if err := store.DeleteDay(bob, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteDay for another user's record returned %v not ErrNotFound", err)
+	}
+
+	records, err := store.ListDays(bob, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID == id {
+		t.Errorf("ListDays for bob returned %+v", records)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	createTestUser(t, store, "alice")
+
+	if _, err := store.CreateUser("alice", "another password", ""); !errors.Is(err, ErrDuplicateUser) {
+		t.Errorf("CreateUser with a taken name returned %v not ErrDuplicateUser", err)
+	}
+
+	user, err := store.Authenticate("alice", "correct horse")
+	if err != nil || user.Username != "alice" {
+		t.Errorf("Authenticate returned %+v, %v", user, err)
+	}
+	if _, err := store.Authenticate("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate with a wrong password returned %v", err)
+	}
+	if _, err := store.Authenticate("mallory", "correct horse"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate with an unknown user returned %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	userID := createTestUser(t, store, "alice")
+
+	plaintext, token, err := store.CreateToken(userID, "phone", []string{ScopeRead, ScopeWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, authed, err := store.AuthenticateToken(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != userID || authed.ID != token.ID {
+		t.Errorf("AuthenticateToken returned %+v and %+v", user, authed)
+	}
+	if !authed.HasScope(ScopeWrite) || authed.HasScope(ScopeDelete) {
+		t.Errorf("AuthenticateToken returned scopes %v", authed.Scopes)
+	}
+
+	// The last use is only rewritten once it is over a minute old.
+	for _, test := range []struct {
+		stored  string
+		updated bool
+	}{
+		{"datetime('now', '-10 seconds')", false},
+		{"'2000-01-01 00:00:00'", true},
+	} {
+		var before, after string
+		if _, err := store.db.Exec("UPDATE tokens SET last_used_at = "+test.stored+" WHERE id = ?", token.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.db.QueryRow("SELECT last_used_at FROM tokens WHERE id = ?", token.ID).Scan(&before); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := store.AuthenticateToken(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.db.QueryRow("SELECT last_used_at FROM tokens WHERE id = ?", token.ID).Scan(&after); err != nil {
+			t.Fatal(err)
+		}
+		if (after != before) != test.updated {
+			t.Errorf("AuthenticateToken changed a last use of %v to %v", before, after)
+		}
+	}
+
+	var stored string
+	if err := store.db.QueryRow("SELECT token_hash FROM tokens WHERE id = ?", token.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == plaintext {
+		t.Error("The plaintext token was stored")
+	}
+
+	if err := store.RevokeToken(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.AuthenticateToken(plaintext); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("AuthenticateToken with a revoked token returned %v", err)
+	}
+	if err := store.RevokeToken(token.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoking twice returned %v not ErrNotFound", err)
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("read, write")
+	if err != nil || len(scopes) != 2 {
+		t.Errorf("ParseScopes returned %v, %v", scopes, err)
+	}
+	if _, err := ParseScopes("read,admin"); err == nil {
+		t.Error("ParseScopes accepted an unknown scope")
+	}
+	if _, err := ParseScopes(""); err == nil {
+		t.Error("ParseScopes accepted an empty list")
+	}
+}
+
+func TestImportDays(t *testing.T) {
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	weight := func(kg float64) sql.NullFloat64 { return sql.NullFloat64{Float64: kg, Valid: true} }
+	calories := func(kcal float64) sql.NullFloat64 { return sql.NullFloat64{Float64: kcal, Valid: true} }
+
+	imported := []DayRecord{
+		{Time: day, Weight: weight(79.5)},
+		{Time: day.AddDate(0, 0, 1), Weight: weight(79.4), Calories: calories(2500)},
+		{Time: day.AddDate(0, 0, 1), Calories: calories(2600)},
+	}
+
+	tests := []struct {
+		mode         ConflictMode
+		wantUpdated  int
+		wantSkipped  int
+		wantExisting DayRecord
+		wantRepeated DayRecord
+	}{
+		{ConflictSkip, 0, 2, DayRecord{Weight: weight(80), Calories: calories(2400)}, DayRecord{Weight: weight(79.4), Calories: calories(2500)}},
+		{ConflictOverwrite, 2, 0, DayRecord{Weight: weight(79.5)}, DayRecord{Calories: calories(2600)}},
+		{ConflictMerge, 2, 0, DayRecord{Weight: weight(79.5), Calories: calories(2400)}, DayRecord{Weight: weight(79.4), Calories: calories(2600)}},
+	}
+	for _, test := range tests {
+		store := openMemoryStore(t)
+		userID := createTestUser(t, store, "alice")
+		if _, err := store.CreateDay(userID, DayRecord{Time: day, Weight: weight(80), Calories: calories(2400)}); err != nil {
+			t.Fatal(err)
+		}
+
+		dryRun, err := store.ImportDays(userID, imported, test.mode, true)
+		if err != nil {
+			t.Fatalf("ImportDays(%s) dry run returned %v", test.mode, err)
+		}
+		if records, _ := store.ListDays(userID, 10); len(records) != 1 {
+			t.Errorf("ImportDays(%s) dry run wrote %d records", test.mode, len(records)-1)
+		}
+
+		result, err := store.ImportDays(userID, imported, test.mode, false)
+		if err != nil {
+			t.Fatalf("ImportDays(%s) returned %v", test.mode, err)
+		}
+		if result.Inserted != 1 || result.Updated != test.wantUpdated || result.Skipped != test.wantSkipped {
+			t.Errorf("ImportDays(%s) returned %+v", test.mode, result)
+		}
+		if len(result.Conflicts) != 2 || result.Conflicts[0].Index != 0 || result.Conflicts[1].Index != 2 ||
+			result.Conflicts[0].Existing.Weight != weight(80) {
+			t.Errorf("ImportDays(%s) returned conflicts %+v", test.mode, result.Conflicts)
+		}
+		if dryRun.Inserted != result.Inserted || dryRun.Updated != result.Updated || len(dryRun.Conflicts) != len(result.Conflicts) {
+			t.Errorf("ImportDays(%s) dry run returned %+v not %+v", test.mode, dryRun, result)
+		}
+
+		existing, err := store.GetDayByDate(userID, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if existing.Weight != test.wantExisting.Weight || existing.Calories != test.wantExisting.Calories {
+			t.Errorf("ImportDays(%s) left %+v not %+v", test.mode, existing, test.wantExisting)
+		}
+		repeated, err := store.GetDayByDate(userID, day.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repeated.Weight != test.wantRepeated.Weight || repeated.Calories != test.wantRepeated.Calories {
+			t.Errorf("ImportDays(%s) left %+v not %+v for a date repeated in the import", test.mode, repeated, test.wantRepeated)
+		}
+		store.Close()
+	}
+}
+
+func TestMeasurementsAggregateIntoDays(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	userID := createTestUser(t, store, "alice")
+	otherID := createTestUser(t, store, "bob")
+
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := store.CreateDay(userID, DayRecord{Time: day, Weight: sql.NullFloat64{Float64: 85, Valid: true}}); err != nil {
+		t.Fatal(err)
+	}
+	// Logged out of order: evening, morning, then a late snack check.
+	weighIns := []struct {
+		hour   int
+		weight float64
+	}{{21, 81.0}, {7, 80.0}, {23, 80.6}}
+	var dayID int64
+	for _, weighIn := range weighIns {
+		m, err := store.AddMeasurement(userID, day, day.Add(time.Duration(weighIn.hour)*time.Hour), weighIn.weight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dayID = m.DayID
+	}
+
+	tests := map[Aggregation]float64{
+		AggregateFirst:  80.0,
+		AggregateMin:    80.0,
+		AggregateMean:   80.533,
+		AggregateMedian: 80.6,
+	}
+	for agg, want := range tests {
+		store.SetWeightAggregation(agg)
+		records, err := store.ListDays(userID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || math.Abs(records[0].Weight.Float64-want) > 0.001 {
+			t.Errorf("ListDays with %s aggregation returned %+v not a weight of %v", agg, records, want)
+		}
+		record, err := store.GetDay(userID, dayID)
+		if err != nil || math.Abs(record.Weight.Float64-want) > 0.001 {
+			t.Errorf("GetDay with %s aggregation returned %+v, %v not a weight of %v", agg, record, err, want)
+		}
+	}
+
+	measurements, err := store.ListMeasurements(userID, dayID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != 3 || measurements[0].Weight != 80.0 || measurements[2].Weight != 80.6 {
+		t.Errorf("ListMeasurements returned %+v", measurements)
+	}
+	if _, err := store.ListMeasurements(otherID, dayID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ListMeasurements for another user's day returned %v not ErrNotFound", err)
+	}
+	if err := store.DeleteMeasurement(otherID, measurements[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteMeasurement of another user's weigh-in returned %v not ErrNotFound", err)
+	}
+
+	// A weigh-in on a day without a record creates one.
+	m, err := store.AddMeasurement(userID, day.AddDate(0, 0, 1), day.AddDate(0, 0, 1), 79.8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := store.GetDay(userID, m.DayID)
+	if err != nil || next.Weight.Float64 != 79.8 || next.Calories.Valid {
+		t.Errorf("AddMeasurement created %+v, %v", next, err)
+	}
+
+	if err := store.DeleteDay(userID, dayID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteMeasurement(userID, measurements[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteMeasurement after deleting its day returned %v not ErrNotFound", err)
+	}
+}
+
+func TestWritesKeepMeasuredWeight(t *testing.T) {
+	store := openMemoryStore(t)
+	defer store.Close()
+	userID := createTestUser(t, store, "alice")
+
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	m, err := store.AddMeasurement(userID, day, day.Add(7*time.Hour), 80.04)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weight := func(kg float64) sql.NullFloat64 { return sql.NullFloat64{Float64: kg, Valid: true} }
+	calories := sql.NullFloat64{Float64: 2500, Valid: true}
+
+	if err := store.PatchDay(userID, DayRecord{ID: m.DayID, Weight: weight(75)}); !errors.Is(err, ErrMeasuredWeight) {
+		t.Errorf("PatchDay with a new weight returned %v not ErrMeasuredWeight", err)
+	}
+	if err := store.UpdateDay(userID, DayRecord{ID: m.DayID, Time: day, Weight: weight(75)}); !errors.Is(err, ErrMeasuredWeight) {
+		t.Errorf("UpdateDay with a new weight returned %v not ErrMeasuredWeight", err)
+	}
+	if _, err := store.UpsertDay(userID, DayRecord{Time: day, Weight: weight(75)}); !errors.Is(err, ErrMeasuredWeight) {
+		t.Errorf("UpsertDay with a new weight returned %v not ErrMeasuredWeight", err)
+	}
+	for _, mode := range []ConflictMode{ConflictOverwrite, ConflictMerge} {
+		result, err := store.ImportDays(userID, []DayRecord{{Time: day, Weight: weight(75)}}, mode, false)
+		if err != nil || len(result.Conflicts) != 1 || !result.Conflicts[0].Measured {
+			t.Errorf("ImportDays with %s and a new weight returned %+v, %v", mode, result, err)
+		}
+	}
+
+	// The weight the day is shown with, and writes without a weight, are accepted.
+	if err := store.PatchDay(userID, DayRecord{ID: m.DayID, Calories: calories}); err != nil {
+		t.Errorf("PatchDay without a weight returned %v", err)
+	}
+	if err := store.UpdateDay(userID, DayRecord{ID: m.DayID, Time: day, Weight: weight(80), Calories: calories}); err != nil {
+		t.Errorf("UpdateDay with the shown weight returned %v", err)
+	}
+
+	record, err := store.GetDay(userID, m.DayID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Weight.Float64 != 80.04 || record.Calories.Float64 != 2500 {
+		t.Errorf("GetDay returned %+v", record)
+	}
+}
+
+func TestParseAggregation(t *testing.T) {
+	for _, name := range []string{"first", "min", "mean", "median"} {
+		if agg, err := ParseAggregation(name); err != nil || string(agg) != name {
+			t.Errorf("ParseAggregation(%q) returned %v, %v", name, agg, err)
+		}
+	}
+	if _, err := ParseAggregation("max"); err == nil {
+		t.Error("ParseAggregation accepted max")
+	}
+}
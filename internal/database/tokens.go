@@ -0,0 +1,158 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Token scopes. Read allows fetching data, write allows creating and changing days and delete allows
+// removing them, so a device that only logs weights can be given read and write alone.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+)
+
+// tokenPrefix marks strings as bulkTracker tokens so they are easy to spot if leaked.
+const tokenPrefix = "bt_"
+
+// Token is an API token belonging to a user. Only a hash of the token itself is stored.
+type Token struct {
+	ID        int64
+	UserID    int64
+	Name      string
+	Scopes    []string
+	CreatedAt string
+	LastUsed  sql.NullString
+	RevokedAt sql.NullString
+}
+
+// HasScope reports whether the token was granted scope.
+func (t Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes splits a comma separated scope list and checks every entry is known.
+func ParseScopes(list string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(list, ",") {
+		scope = strings.TrimSpace(scope)
+		switch scope {
+		case ScopeRead, ScopeWrite, ScopeDelete:
+			scopes = append(scopes, scope)
+		case "":
+		default:
+			return nil, fmt.Errorf("database: unknown scope %q", scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("database: at least one scope is required")
+	}
+	return scopes, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// CreateToken mints a token for the user. The returned plaintext is not stored and cannot be
+// recovered later.
+func (s *Store) CreateToken(userID int64, name string, scopes []string) (string, Token, error) {
+	random, err := randomString()
+	if err != nil {
+		return "", Token{}, err
+	}
+	plaintext := tokenPrefix + random
+
+	result, err := s.db.Exec("INSERT INTO tokens(user_id, name, token_hash, scopes) VALUES (?, ?, ?, ?)",
+		userID, name, hashToken(plaintext), strings.Join(scopes, ","))
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", Token{}, err
+	}
+	return plaintext, Token{ID: id, UserID: userID, Name: name, Scopes: scopes}, nil
+}
+
+// AuthenticateToken returns the token and its user for a plaintext token, or ErrInvalidCredentials if
+// it is unknown or revoked. The token's last use is recorded at most once a minute, and failing to record
+// it does not fail the authentication.
+func (s *Store) AuthenticateToken(plaintext string) (User, Token, error) {
+	var user User
+	var token Token
+	var scopes string
+
+	err := s.db.QueryRow(`SELECT t.id, t.user_id, t.name, t.scopes, t.created_at, u.id, u.username, u.timezone
+		FROM tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND t.revoked_at IS NULL`, hashToken(plaintext)).
+		Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.CreatedAt, &user.ID, &user.Username, &user.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, Token{}, ErrInvalidCredentials
+	} else if err != nil {
+		return User{}, Token{}, err
+	}
+	token.Scopes = strings.Split(scopes, ",")
+
+	_, err = s.db.Exec(`UPDATE tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute'))`, token.ID)
+	if err != nil {
+		log.Print(err)
+	}
+	return user, token, nil
+}
+
+// RevokeToken stops the token with the given id from authenticating.
+func (s *Store) RevokeToken(id int64) error {
+	result, err := s.db.Exec("UPDATE tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// ListTokens returns the user's tokens, including revoked ones, ordered by id.
+func (s *Store) ListTokens(userID int64) ([]Token, error) {
+	rows, err := s.db.Query(`SELECT id, user_id, name, scopes, created_at, last_used_at, revoked_at
+		FROM tokens WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var token Token
+		var scopes string
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.CreatedAt, &token.LastUsed, &token.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
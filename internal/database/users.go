@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrDuplicateUser is returned when creating a user whose username is already taken.
+var ErrDuplicateUser = errors.New("database: username already exists")
+
+// ErrInvalidCredentials is returned when a username and password do not match a user.
+var ErrInvalidCredentials = errors.New("database: invalid username or password")
+
+// User is a person tracking their own day records. Timezone is an IANA name and may be empty, in
+// which case the server's configured timezone applies.
+type User struct {
+	ID       int64
+	Username string
+	Timezone string
+}
+
+// CreateUser adds a user with a bcrypt hash of password.
+func (s *Store) CreateUser(username string, password string, timezone string) (User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	result, err := s.db.Exec("INSERT INTO users(username, password_hash, timezone) VALUES (?, ?, ?)",
+		username, string(hash), timezone)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return User{}, ErrDuplicateUser
+		}
+		return User{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return User{ID: id, Username: username, Timezone: timezone}, nil
+}
+
+// SetPassword replaces the password of the named user.
+func (s *Store) SetPassword(username string, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec("UPDATE users SET password_hash=? WHERE username=?", string(hash), username)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// Authenticate returns the user matching username and password, or ErrInvalidCredentials.
+func (s *Store) Authenticate(username string, password string) (User, error) {
+	var user User
+	var hash string
+
+	err := s.db.QueryRow("SELECT id, username, timezone, password_hash FROM users WHERE username=?", username).
+		Scan(&user.ID, &user.Username, &user.Timezone, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrInvalidCredentials
+	} else if err != nil {
+		return User{}, err
+	}
+
+	// Accounts migrated from single-user databases have no password until one is set.
+	if hash == "" || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// GetUser returns the user with the given id, or ErrNotFound.
+func (s *Store) GetUser(id int64) (User, error) {
+	var user User
+
+	err := s.db.QueryRow("SELECT id, username, timezone FROM users WHERE id=?", id).
+		Scan(&user.ID, &user.Username, &user.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	return user, err
+}
+
+// GetUserByName returns the user with the given username, or ErrNotFound.
+func (s *Store) GetUserByName(username string) (User, error) {
+	var user User
+
+	err := s.db.QueryRow("SELECT id, username, timezone FROM users WHERE username=?", username).
+		Scan(&user.ID, &user.Username, &user.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	return user, err
+}
+
+// ListUsers returns every user ordered by id.
+func (s *Store) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT id, username, timezone FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Timezone); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,113 @@
+// Package export writes day records and analysis reports as CSV and XLSX spreadsheets.
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/analysis"
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+	"git.ebain.es/healthAndFitnessTracker/internal/helpers"
+)
+
+// Options controls how dates and values are written.
+type Options struct {
+	// DateFormat is the Go layout used for the date column.
+	DateFormat string
+	// DecimalPlaces is the number of decimal places values are rounded to.
+	DecimalPlaces int
+}
+
+// Table is a spreadsheet with a date in the first column and numbers, or empty cells, in the rest.
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Format is a file format a Table can be written in.
+type Format struct {
+	Extension   string
+	ContentType string
+	Write       func(w io.Writer, table *Table) error
+}
+
+var CSV = Format{Extension: "csv", ContentType: "text/csv; charset=utf-8", Write: WriteCSV}
+var XLSX = Format{Extension: "xlsx", ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Write: WriteXLSX}
+
+// ParseFormat returns the format with the given extension.
+func ParseFormat(extension string) (Format, error) {
+	switch extension {
+	case CSV.Extension:
+		return CSV, nil
+	case XLSX.Extension:
+		return XLSX, nil
+	}
+	return Format{}, fmt.Errorf("export: unknown format %q, expected csv or xlsx", extension)
+}
+
+func formatValue(value sql.NullFloat64, opts Options) string {
+	if !value.Valid {
+		return ""
+	}
+	rounded := helpers.RoundDecimalPlaces(value.Float64, opts.DecimalPlaces)
+	if rounded == 0 {
+		// Small negative values round to -0, which spreadsheets show as a negative number.
+		rounded = 0
+	}
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
+
+// Days returns a table of the raw weight and calories logged each day.
+func Days(records []database.DayRecord, opts Options) *Table {
+	table := &Table{
+		Header: []string{"date", "weight_kg", "calories_kcal"},
+		Rows:   make([][]string, 0, len(records)),
+	}
+	for _, record := range records {
+		table.Rows = append(table.Rows, []string{
+			record.Time.Format(opts.DateFormat),
+			formatValue(record.Weight, opts),
+			formatValue(record.Calories, opts),
+		})
+	}
+	return table
+}
+
+// Report returns a table of the computed series, with the same columns as the /table page.
+func Report(report *analysis.Report, opts Options) *Table {
+	table := &Table{
+		Header: []string{
+			"date", "weight_kg", "calories_kcal", "weight_change_kg", "weight_change_7_kg",
+			"weight_change_28_kg", "calorie_change_7_kcal", "tdee_kcal",
+		},
+		Rows: make([][]string, 0, report.Len()),
+	}
+	for i, date := range report.Dates {
+		table.Rows = append(table.Rows, []string{
+			date.Format(opts.DateFormat),
+			formatValue(report.Weight[i], opts),
+			formatValue(report.Calories[i], opts),
+			formatValue(report.WeightChange[i], opts),
+			formatValue(report.WeightChange7[i], opts),
+			formatValue(report.WeightChange28[i], opts),
+			formatValue(report.CalorieChange7[i], opts),
+			formatValue(report.TDEE[i], opts),
+		})
+	}
+	return table
+}
+
+// WriteCSV writes table as CSV with a header row.
+func WriteCSV(w io.Writer, table *Table) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(table.Header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(table.Rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
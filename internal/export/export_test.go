@@ -0,0 +1,97 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+)
+
+var testRecords = []database.DayRecord{
+	{
+		Time:     time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		Weight:   sql.NullFloat64{Float64: 80.25, Valid: true},
+		Calories: sql.NullFloat64{Float64: 2500, Valid: true},
+	},
+	{
+		Time:   time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC),
+		Weight: sql.NullFloat64{Float64: 80.04, Valid: true},
+	},
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buffer bytes.Buffer
+	table := Days(testRecords, Options{DateFormat: "02/01/2006", DecimalPlaces: 1})
+	if err := WriteCSV(&buffer, table); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "date,weight_kg,calories_kcal\n01/03/2021,80.3,2500\n02/03/2021,80,\n"
+	if buffer.String() != want {
+		t.Errorf("WriteCSV wrote %q not %q", buffer.String(), want)
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buffer bytes.Buffer
+	table := Days(testRecords, Options{DateFormat: "2006-01-02", DecimalPlaces: 2})
+	if err := WriteXLSX(&buffer, table); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatalf("WriteXLSX wrote an invalid zip: %v", err)
+	}
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := xml.Unmarshal(content, new(interface{})); err != nil {
+			t.Errorf("WriteXLSX wrote invalid XML in %s: %v", f.Name, err)
+		}
+		parts[f.Name] = string(content)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("WriteXLSX did not write %s", name)
+		}
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, cell := range []string{
+		`<c r="A1" t="inlineStr"><is><t>date</t></is></c>`,
+		`<c r="A2" t="inlineStr"><is><t>2021-03-01</t></is></c>`,
+		`<c r="B2"><v>80.25</v></c>`,
+		`<c r="C2"><v>2500</v></c>`,
+	} {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("WriteXLSX sheet does not contain %s", cell)
+		}
+	}
+	if strings.Contains(sheet, `r="C3"`) {
+		t.Errorf("WriteXLSX wrote a cell for a missing value")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{0: "A", 7: "H", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for col, want := range tests {
+		if name := columnName(col); name != want {
+			t.Errorf("columnName(%d) returned %s not %s", col, name, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// The parts of a minimal workbook with a single worksheet, which is written by writeSheet.
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// WriteXLSX writes table as an Excel workbook. The header and dates are written as text and the
+// other columns as numbers.
+func WriteXLSX(w io.Writer, table *Table) error {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if err := writeSheet(f, table); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func writeSheet(w io.Writer, table *Table) error {
+	b := bufio.NewWriter(w)
+	b.WriteString(xml.Header)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	writeRow := func(row int, cells []string, header bool) {
+		b.WriteString(`<row r="` + strconv.Itoa(row) + `">`)
+		for col, cell := range cells {
+			if cell == "" {
+				continue
+			}
+			ref := columnName(col) + strconv.Itoa(row)
+			if header || col == 0 {
+				b.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t>`)
+				xml.EscapeText(b, []byte(cell))
+				b.WriteString(`</t></is></c>`)
+			} else {
+				b.WriteString(`<c r="` + ref + `"><v>` + cell + `</v></c>`)
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+
+	writeRow(1, table.Header, true)
+	for i, cells := range table.Rows {
+		writeRow(i+2, cells, false)
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.Flush()
+}
+
+// columnName returns the spreadsheet name of the zero-based column index, e.g. 0 is A and 26 is AA.
+func columnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name
+}
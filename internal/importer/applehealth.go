@@ -0,0 +1,79 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	appleBodyMass      = "HKQuantityTypeIdentifierBodyMass"
+	appleDietaryEnergy = "HKQuantityTypeIdentifierDietaryEnergyConsumed"
+	appleDateFormat    = "2006-01-02 15:04:05 -0700"
+)
+
+// ParseAppleHealth reads the body mass and dietary energy records in the export.xml of an Apple Health
+// export. The file is streamed, as exports with years of heart rate samples run to gigabytes.
+func ParseAppleHealth(r io.Reader, opts Options) ([]Row, []RowError, error) {
+	days := newDayAggregator(opts.Location)
+	var rowErrors []RowError
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("importer: reading Apple Health export: %w", err)
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "Record" {
+			continue
+		}
+		attrs := map[string]string{}
+		for _, attr := range element.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		if attrs["type"] != appleBodyMass && attrs["type"] != appleDietaryEnergy {
+			continue
+		}
+
+		if err := addAppleRecord(days, attrs); err != nil {
+			rowErrors = append(rowErrors, RowError{Message: fmt.Sprintf("%s record at %s: %v", attrs["type"], attrs["startDate"], err)})
+		}
+	}
+	return days.rows(), rowErrors, nil
+}
+
+func addAppleRecord(days *dayAggregator, attrs map[string]string) error {
+	t, err := time.Parse(appleDateFormat, attrs["startDate"])
+	if err != nil {
+		return fmt.Errorf("invalid date")
+	}
+	value, err := strconv.ParseFloat(attrs["value"], 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", attrs["value"])
+	}
+	unit, err := ParseUnit(attrs["unit"])
+	if err != nil {
+		return err
+	}
+
+	if attrs["type"] == appleBodyMass {
+		kg, err := toKilograms(value, unit)
+		if err != nil {
+			return err
+		}
+		days.addWeight(t, kg)
+		return nil
+	}
+	kcal, err := toKilocalories(value, unit)
+	if err != nil {
+		return err
+	}
+	days.addCalories(t, kcal)
+	return nil
+}
@@ -0,0 +1,175 @@
+package importer
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// wantDay is an expected aggregated day. Zero values are expected to be missing.
+type wantDay struct {
+	date     string
+	weight   float64
+	calories float64
+}
+
+func checkRows(t *testing.T, name string, rows []Row, want []wantDay) {
+	t.Helper()
+	if len(rows) != len(want) {
+		t.Fatalf("%s returned %d days not %d: %+v", name, len(rows), len(want), rows)
+	}
+	for i, row := range rows {
+		if date := row.Date.Format("2006-01-02"); date != want[i].date {
+			t.Errorf("%s returned %s not %s for day %d", name, date, want[i].date, i)
+		}
+		checkValue(t, name+" "+want[i].date+" weight", row.Weight, want[i].weight)
+		checkValue(t, name+" "+want[i].date+" calories", row.Calories, want[i].calories)
+	}
+}
+
+func checkValue(t *testing.T, name string, value *float64, want float64) {
+	t.Helper()
+	if want == 0 {
+		if value != nil {
+			t.Errorf("%s is %v not missing", name, *value)
+		}
+	} else if value == nil {
+		t.Errorf("%s is missing not %v", name, want)
+	} else if math.Abs(*value-want) > 0.01 {
+		t.Errorf("%s is %v not %v", name, *value, want)
+	}
+}
+
+func TestParsers(t *testing.T) {
+	tests := []struct {
+		format     string
+		file       string
+		opts       Options
+		want       []wantDay
+		wantErrors int
+	}{
+		{
+			format: "apple-health",
+			file:   "apple_health_export.xml",
+			want: []wantDay{
+				{"2021-03-01", 80.4, 2650},
+				{"2021-03-02", 80.01, 2000},
+			},
+			wantErrors: 1,
+		},
+		{
+			format: "google-fit",
+			file:   "google_fit_takeout_weight.json",
+			want: []wantDay{
+				{"2021-03-01", 80.4, 0},
+				{"2021-03-02", 80.0, 0},
+			},
+		},
+		{
+			format: "google-fit",
+			file:   "google_fit_nutrition.json",
+			want: []wantDay{
+				{"2021-03-01", 0, 2500},
+			},
+			wantErrors: 1,
+		},
+		{
+			format: "fitbit",
+			file:   "fitbit_weight_export.json",
+			opts:   Options{WeightUnit: Pounds},
+			want: []wantDay{
+				{"2021-03-01", 80.38, 0},
+				{"2021-03-02", 80.01, 0},
+			},
+		},
+		{
+			format: "fitbit",
+			file:   "fitbit_food_log.json",
+			want: []wantDay{
+				{"2021-03-01", 0, 2500},
+				{"2021-03-02", 0, 2300},
+			},
+		},
+		{
+			format: "garmin",
+			file:   "garmin_weight.csv",
+			want: []wantDay{
+				{"2021-03-01", 80.4, 0},
+				{"2021-03-02", 80.01, 0},
+			},
+			wantErrors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		f, err := os.Open(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, rowErrors, err := Parsers[test.format](f, test.opts)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s returned %v", test.file, err)
+			continue
+		}
+		checkRows(t, test.file, rows, test.want)
+		if len(rowErrors) != test.wantErrors {
+			t.Errorf("%s returned errors %v, expected %d", test.file, rowErrors, test.wantErrors)
+		}
+	}
+}
+
+func TestParsersUseLocation(t *testing.T) {
+	// The 21:00 UTC weigh-in on the 1st is the morning of the 2nd in Sydney, so it is the first
+	// weigh-in of the 2nd rather than the last of the 1st.
+	loc, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Skip(err)
+	}
+	f, err := os.Open(filepath.Join("testdata", "google_fit_takeout_weight.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, _, err := ParseGoogleFit(f, Options{Location: loc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, "google_fit_takeout_weight.json in Sydney", rows, []wantDay{
+		{"2021-03-01", 80.4, 0},
+		{"2021-03-02", 81.3, 0},
+	})
+}
+
+func TestParseUnit(t *testing.T) {
+	tests := map[string]Unit{
+		"kg": Kilograms, "LBS": Pounds, "st": Stones, "Cal": Kilocalories, "kcal": Kilocalories, "cal": Calories,
+		"kJ": Kilojoules,
+	}
+	for s, want := range tests {
+		if unit, err := ParseUnit(s); err != nil || unit != want {
+			t.Errorf("ParseUnit(%q) returned %v, %v not %v", s, unit, err, want)
+		}
+	}
+	if _, err := ParseUnit("furlong"); err == nil {
+		t.Error("ParseUnit accepted furlong")
+	}
+}
+
+func TestToKilocalories(t *testing.T) {
+	tests := []struct {
+		unit Unit
+		want float64
+	}{{Kilocalories, 2500}, {Calories, 2.5}, {Kilojoules, 2500 / 4.184}}
+	for _, test := range tests {
+		if kcal, err := toKilocalories(2500, test.unit); err != nil || kcal != test.want {
+			t.Errorf("toKilocalories(2500, %v) returned %v, %v not %v", test.unit, kcal, err, test.want)
+		}
+	}
+	if _, err := toKilocalories(2500, Kilograms); err == nil {
+		t.Error("toKilocalories accepted kilograms")
+	}
+}
@@ -0,0 +1,183 @@
+// Package importer reads day records exported by other applications.
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	database "git.ebain.es/healthAndFitnessTracker/internal/database"
+)
+
+// Row is one day read from an import file. Weight and Calories are nil when the file has no value.
+// Line is 0 for days aggregated from several samples.
+type Row struct {
+	Line     int
+	Date     time.Time
+	Weight   *float64
+	Calories *float64
+}
+
+// RowError describes a line or sample of an import file that could not be read. Line is 0 for
+// formats without lines.
+type RowError struct {
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"error"`
+}
+
+func (e RowError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Mapping names the CSV columns holding each field. An empty Weight or Calories column is not imported.
+type Mapping struct {
+	Date     string
+	Weight   string
+	Calories string
+}
+
+// DefaultMapping matches the columns written by the CSV export.
+var DefaultMapping = Mapping{Date: "date", Weight: "weight_kg", Calories: "calories_kcal"}
+
+// DefaultDateFormats are the Go layouts tried when no date formats are given.
+var DefaultDateFormats = []string{database.DateFormat, time.RFC3339}
+
+// CSVOptions controls how ParseCSV reads a file.
+type CSVOptions struct {
+	Mapping Mapping
+	// DateFormats are the Go layouts tried, in order, for each date.
+	DateFormats []string
+	// Location is the timezone of dates that include a time of day, which decides their calendar day.
+	Location *time.Location
+	// Comma is the field delimiter, defaulting to ','.
+	Comma rune
+}
+
+// ParseCSV reads the day records in a CSV file with a header row. Lines that cannot be read are
+// returned as RowErrors rather than failing the whole file; the error is only set when the file
+// itself is unusable, such as a missing header or mapped column.
+func ParseCSV(r io.Reader, opts CSVOptions) ([]Row, []RowError, error) {
+	if opts.Mapping.Date == "" {
+		return nil, nil, errors.New("importer: a date column is required")
+	}
+	if len(opts.DateFormats) == 0 {
+		opts.DateFormats = DefaultDateFormats
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if opts.Comma != 0 {
+		reader.Comma = opts.Comma
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("importer: the file is empty")
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("importer: reading header: %w", err)
+	}
+
+	dateCol, err := findColumn(header, opts.Mapping.Date)
+	if err != nil {
+		return nil, nil, err
+	}
+	weightCol, err := findColumn(header, opts.Mapping.Weight)
+	if err != nil {
+		return nil, nil, err
+	}
+	calorieCol, err := findColumn(header, opts.Mapping.Calories)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []Row
+	var rowErrors []RowError
+	// The header is line 1. Line numbers assume that no field spans lines.
+	for line := 2; ; line++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErrors = append(rowErrors, RowError{Line: line, Message: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, fmt.Errorf("importer: %w", err)
+		}
+
+		row := Row{Line: line}
+		if row.Date, err = parseDate(field(fields, dateCol), opts.DateFormats, opts.Location); err != nil {
+			rowErrors = append(rowErrors, RowError{Line: line, Message: err.Error()})
+			continue
+		}
+		if row.Weight, err = parseValue(field(fields, weightCol)); err != nil {
+			rowErrors = append(rowErrors, RowError{Line: line, Message: "invalid weight: " + err.Error()})
+			continue
+		}
+		if row.Calories, err = parseValue(field(fields, calorieCol)); err != nil {
+			rowErrors = append(rowErrors, RowError{Line: line, Message: "invalid calories: " + err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+// findColumn returns the index of the column called name, ignoring case and surrounding space, or -1
+// when name is empty.
+func findColumn(header []string, name string) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	for i, column := range header {
+		if strings.EqualFold(strings.TrimSpace(column), strings.TrimSpace(name)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("importer: no column named %q in the header", name)
+}
+
+func field(fields []string, col int) string {
+	if col < 0 || col >= len(fields) {
+		return ""
+	}
+	return strings.TrimSpace(fields[col])
+}
+
+// parseDate parses value with the first matching layout and returns midnight UTC of its calendar day.
+func parseDate(value string, layouts []string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("missing date")
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date %q does not match any of the formats %s", value, strings.Join(layouts, ", "))
+}
+
+func parseValue(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a number", value)
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCSV(t *testing.T) {
+	file := `Date;Body Weight;Energy (kcal);Notes
+01/03/2021;80.2;2500;fine
+02/03/2021;;2400;
+03/03/2021;80.0;;
+bad;80;2000;
+04/03/2021;heavy;2000;
+`
+	opts := CSVOptions{
+		Mapping:     Mapping{Date: "date", Weight: "body weight", Calories: "Energy (kcal)"},
+		DateFormats: []string{"02/01/2006"},
+		Comma:       ';',
+	}
+	rows, rowErrors, err := ParseCSV(strings.NewReader(file), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("ParseCSV returned %d rows not %d", len(rows), 3)
+	}
+	first := rows[0]
+	if first.Line != 2 || !first.Date.Equal(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)) ||
+		first.Weight == nil || *first.Weight != 80.2 || first.Calories == nil || *first.Calories != 2500 {
+		t.Errorf("ParseCSV returned %+v for the first row", first)
+	}
+	if rows[1].Weight != nil || rows[2].Calories != nil {
+		t.Errorf("ParseCSV returned values for empty cells")
+	}
+
+	if len(rowErrors) != 2 || rowErrors[0].Line != 5 || rowErrors[1].Line != 6 {
+		t.Fatalf("ParseCSV returned row errors %v", rowErrors)
+	}
+	if !strings.Contains(rowErrors[1].Message, "weight") {
+		t.Errorf("ParseCSV returned %q for an invalid weight", rowErrors[1].Message)
+	}
+}
+
+func TestParseCSVDateTimes(t *testing.T) {
+	// 23:30 in New York is the next day in UTC, but the day belongs to the New York calendar.
+	file := "date,weight_kg,calories_kcal\n2021-03-01T23:30:00-05:00,80,\n2021-03-02,,2500\n"
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	rows, rowErrors, err := ParseCSV(strings.NewReader(file), CSVOptions{Mapping: DefaultMapping, Location: loc})
+	if err != nil || len(rowErrors) != 0 {
+		t.Fatalf("ParseCSV returned %v, %v", rowErrors, err)
+	}
+	want := []time.Time{
+		time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC),
+	}
+	for i, row := range rows {
+		if !row.Date.Equal(want[i]) {
+			t.Errorf("ParseCSV returned %v not %v for line %d", row.Date, want[i], row.Line)
+		}
+	}
+}
+
+func TestParseCSVMissingColumn(t *testing.T) {
+	file := "date,weight_kg\n2021-03-01,80\n"
+	if _, _, err := ParseCSV(strings.NewReader(file), CSVOptions{Mapping: DefaultMapping}); err == nil {
+		t.Error("ParseCSV accepted a file without the calories column")
+	}
+
+	mapping := Mapping{Date: "date", Weight: "weight_kg"}
+	rows, _, err := ParseCSV(strings.NewReader(file), CSVOptions{Mapping: mapping})
+	if err != nil || len(rows) != 1 {
+		t.Errorf("ParseCSV without a calories mapping returned %v, %v", rows, err)
+	}
+}
@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fitbitDateFormats are the date layouts of the Fitbit Web API and of the Fitbit data export.
+var fitbitDateFormats = []string{"2006-01-02", "01/02/06"}
+
+// fitbitFile accepts the weight log, food log and calories in time series responses of the Fitbit Web
+// API. The weight files of a Fitbit data export hold a bare array of weight entries instead.
+type fitbitFile struct {
+	Weight     []fitbitWeight `json:"weight"`
+	CaloriesIn []fitbitSeries `json:"foods-log-caloriesIn"`
+	Foods      []fitbitFood   `json:"foods"`
+}
+
+type fitbitWeight struct {
+	Date   string        `json:"date"`
+	Time   string        `json:"time"`
+	Weight *fitbitNumber `json:"weight"`
+}
+
+type fitbitSeries struct {
+	DateTime string        `json:"dateTime"`
+	Value    *fitbitNumber `json:"value"`
+}
+
+type fitbitFood struct {
+	LogDate           string `json:"logDate"`
+	NutritionalValues struct {
+		Calories *fitbitNumber `json:"calories"`
+	} `json:"nutritionalValues"`
+}
+
+// fitbitNumber is a number that the Fitbit API writes either as a JSON number or as a string.
+type fitbitNumber float64
+
+func (n *fitbitNumber) UnmarshalJSON(data []byte) error {
+	parsed, err := strconv.ParseFloat(strings.Trim(string(data), `"`), 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	*n = fitbitNumber(parsed)
+	return nil
+}
+
+// ParseFitbit reads a Fitbit weight or food log, from either the Fitbit data export or the Web API.
+// Fitbit writes weights in the account's unit without naming it, so opts.WeightUnit must be set for
+// accounts that use pounds. Calories are summed per day.
+func ParseFitbit(r io.Reader, opts Options) ([]Row, []RowError, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("importer: reading Fitbit data: %w", err)
+	}
+
+	var file fitbitFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &file.Weight)
+	} else {
+		err = json.Unmarshal(trimmed, &file)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("importer: reading Fitbit data: %w", err)
+	}
+
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	days := newDayAggregator(loc)
+	var rowErrors []RowError
+
+	for _, entry := range file.Weight {
+		t, err := parseFitbitTime(entry.Date, entry.Time, loc)
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Message: "weight: " + err.Error()})
+			continue
+		}
+		if entry.Weight == nil {
+			rowErrors = append(rowErrors, RowError{Message: fmt.Sprintf("weight on %s has no value", entry.Date)})
+			continue
+		}
+		kg, err := toKilograms(float64(*entry.Weight), opts.WeightUnit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("importer: %w", err)
+		}
+		days.addWeight(t, kg)
+	}
+
+	for _, entry := range file.CaloriesIn {
+		t, err := parseFitbitTime(entry.DateTime, "", loc)
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Message: "calories: " + err.Error()})
+			continue
+		}
+		// Days without any food logged are reported as 0 calories rather than left out.
+		if entry.Value == nil || *entry.Value == 0 {
+			continue
+		}
+		days.addCalories(t, float64(*entry.Value))
+	}
+
+	for _, food := range file.Foods {
+		t, err := parseFitbitTime(food.LogDate, "", loc)
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Message: "food: " + err.Error()})
+			continue
+		}
+		if food.NutritionalValues.Calories == nil {
+			rowErrors = append(rowErrors, RowError{Message: fmt.Sprintf("food on %s has no calories", food.LogDate)})
+			continue
+		}
+		days.addCalories(t, float64(*food.NutritionalValues.Calories))
+	}
+
+	return days.rows(), rowErrors, nil
+}
+
+// parseFitbitTime combines a Fitbit date and optional time of day in loc.
+func parseFitbitTime(date string, clock string, loc *time.Location) (time.Time, error) {
+	for _, layout := range fitbitDateFormats {
+		t, err := time.ParseInLocation(layout, date, loc)
+		if err != nil {
+			continue
+		}
+		if clock != "" {
+			if c, err := time.Parse("15:04:05", clock); err == nil {
+				t = time.Date(t.Year(), t.Month(), t.Day(), c.Hour(), c.Minute(), c.Second(), 0, loc)
+			}
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", date)
+}
@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// garminDateFormats are the date layouts Garmin Connect uses for the day headings of a weight export.
+var garminDateFormats = []string{"Jan 2, 2006", "2 Jan 2006", "2006-01-02"}
+
+// garminTimeFormats are the time of day layouts of the weigh-ins under each heading.
+var garminTimeFormats = []string{"3:04 PM", "15:04"}
+
+// ParseGarmin reads the CSV weight export of Garmin Connect. Each day starts with a row holding just
+// the date, followed by a row per weigh-in with its time and weight including the unit, for example:
+//
+//	Time,Weight,Change,BMI,Body Fat,Skeletal Muscle Mass,Bone Mass,Body Water,
+//	" Mar 1, 2021",
+//	7:30 AM,80.2 kg,0.2 kg,24.5,--,--,--,--,
+//
+// Garmin Connect does not record food, so only weights are imported.
+func ParseGarmin(r io.Reader, opts Options) ([]Row, []RowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("importer: the file is empty")
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("importer: reading header: %w", err)
+	}
+	timeCol, err := findColumn(header, "Time")
+	if err != nil {
+		return nil, nil, err
+	}
+	weightCol, err := findColumn(header, "Weight")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	days := newDayAggregator(loc)
+	var rowErrors []RowError
+	var date time.Time
+
+	for line := 2; ; line++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("importer: %w", err)
+		}
+
+		if heading, ok := garminHeading(fields, loc); ok {
+			date = heading
+			continue
+		}
+		if date.IsZero() {
+			rowErrors = append(rowErrors, RowError{Line: line, Message: "weigh-in before the first date"})
+			continue
+		}
+
+		clock, err := parseGarminTime(field(fields, timeCol))
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: line, Message: err.Error()})
+			continue
+		}
+		weight := field(fields, weightCol)
+		if weight == "" || weight == "--" {
+			continue
+		}
+		kg, err := parseGarminWeight(weight)
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Line: line, Message: err.Error()})
+			continue
+		}
+		days.addWeight(time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), 0, 0, loc), kg)
+	}
+	return days.rows(), rowErrors, nil
+}
+
+// garminHeading parses the rows that start each day, which hold a date and nothing else.
+func garminHeading(fields []string, loc *time.Location) (time.Time, bool) {
+	for _, value := range fields[1:] {
+		if strings.TrimSpace(value) != "" {
+			return time.Time{}, false
+		}
+	}
+	for _, layout := range garminDateFormats {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(fields[0]), loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseGarminTime(value string) (time.Time, error) {
+	for _, layout := range garminTimeFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// parseGarminWeight parses a weight such as "80.2 kg" or "176.8 lbs".
+func parseGarminWeight(value string) (float64, error) {
+	parts := strings.Fields(value)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid weight %q", value)
+	}
+	weight, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid weight %q", value)
+	}
+	unit, err := ParseUnit(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	return toKilograms(weight, unit)
+}
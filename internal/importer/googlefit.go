@@ -0,0 +1,111 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	googleFitWeight    = "com.google.weight"
+	googleFitNutrition = "com.google.nutrition"
+)
+
+// googleFitFile accepts both a Google Takeout Fit data file and a dataset from the Fit REST API.
+type googleFitFile struct {
+	TakeoutPoints []googleFitPoint `json:"Data Points"`
+	Points        []googleFitPoint `json:"point"`
+}
+
+type googleFitPoint struct {
+	DataTypeName   string           `json:"dataTypeName"`
+	StartTimeNanos googleFitNanos   `json:"startTimeNanos"`
+	FitValue       []googleFitValue `json:"fitValue"`
+	Value          []googleFitValue `json:"value"`
+}
+
+type googleFitValue struct {
+	// Takeout nests each value in a "value" object.
+	Value  *googleFitValue `json:"value"`
+	FPVal  *float64        `json:"fpVal"`
+	MapVal []struct {
+		Key   string         `json:"key"`
+		Value googleFitValue `json:"value"`
+	} `json:"mapVal"`
+}
+
+// googleFitNanos is a timestamp in nanoseconds, which Takeout writes as a number and the REST API as a string.
+type googleFitNanos int64
+
+func (n *googleFitNanos) UnmarshalJSON(data []byte) error {
+	parsed, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s", data)
+	}
+	*n = googleFitNanos(parsed)
+	return nil
+}
+
+// ParseGoogleFit reads weight and nutrition data points from a Google Fit data file, either one of the
+// files in the "All Data" folder of a Google Takeout or a dataset returned by the Fit REST API.
+// Weights are in kilograms and nutrition in kilocalories, as Google Fit stores them.
+func ParseGoogleFit(r io.Reader, opts Options) ([]Row, []RowError, error) {
+	var file googleFitFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, nil, fmt.Errorf("importer: reading Google Fit data: %w", err)
+	}
+
+	days := newDayAggregator(opts.Location)
+	var rowErrors []RowError
+	for _, point := range append(file.TakeoutPoints, file.Points...) {
+		t := time.Unix(0, int64(point.StartTimeNanos))
+		values := point.Value
+		if len(point.FitValue) > 0 {
+			values = point.FitValue
+		}
+
+		switch point.DataTypeName {
+		case googleFitWeight:
+			if len(values) == 0 || values[0].float() == nil {
+				rowErrors = append(rowErrors, RowError{Message: fmt.Sprintf("weight at %s has no value", t.UTC().Format(time.RFC3339))})
+				continue
+			}
+			days.addWeight(t, *values[0].float())
+		case googleFitNutrition:
+			// The first field holds the nutrients, keyed by name.
+			var calories *float64
+			if len(values) > 0 {
+				calories = values[0].nutrient("calories")
+			}
+			if calories == nil {
+				rowErrors = append(rowErrors, RowError{Message: fmt.Sprintf("nutrition at %s has no calories", t.UTC().Format(time.RFC3339))})
+				continue
+			}
+			days.addCalories(t, *calories)
+		}
+	}
+	return days.rows(), rowErrors, nil
+}
+
+func (v googleFitValue) unwrap() googleFitValue {
+	if v.Value != nil {
+		return *v.Value
+	}
+	return v
+}
+
+func (v googleFitValue) float() *float64 {
+	return v.unwrap().FPVal
+}
+
+func (v googleFitValue) nutrient(key string) *float64 {
+	for _, entry := range v.unwrap().MapVal {
+		if entry.Key == key {
+			return entry.Value.float()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options controls the parsers for fitness app exports.
+type Options struct {
+	// Location is the timezone that decides the calendar day of each sample.
+	Location *time.Location
+	// WeightUnit is the unit of weights in exports that do not state one, defaulting to kilograms.
+	WeightUnit Unit
+}
+
+// Parser reads the weight and calorie samples in a fitness app export and aggregates them into days.
+type Parser func(r io.Reader, opts Options) ([]Row, []RowError, error)
+
+// Parsers are the fitness app export formats that can be imported, keyed by the name used to select
+// them in the API and import command.
+var Parsers = map[string]Parser{
+	"apple-health": ParseAppleHealth,
+	"google-fit":   ParseGoogleFit,
+	"fitbit":       ParseFitbit,
+	"garmin":       ParseGarmin,
+}
+
+// Unit is a unit of weight or energy found in exports.
+type Unit string
+
+const (
+	Kilograms    Unit = "kg"
+	Grams        Unit = "g"
+	Pounds       Unit = "lb"
+	Stones       Unit = "st"
+	Kilocalories Unit = "kcal"
+	// Calories are small calories, a thousandth of the Calories on food labels.
+	Calories   Unit = "cal"
+	Kilojoules Unit = "kJ"
+)
+
+// ParseUnit recognises the spellings of units used by the supported apps.
+func ParseUnit(s string) (Unit, error) {
+	// Apple Health writes food labels' Calories, which are kilocalories, as "Cal" and small calories as "cal",
+	// so those two are told apart by case.
+	switch strings.TrimSpace(s) {
+	case "Cal":
+		return Kilocalories, nil
+	case "cal":
+		return Calories, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "kg", "kgs", "kilogram", "kilograms":
+		return Kilograms, nil
+	case "g", "gram", "grams":
+		return Grams, nil
+	case "lb", "lbs", "pound", "pounds":
+		return Pounds, nil
+	case "st", "stone", "stones":
+		return Stones, nil
+	case "kcal", "calories":
+		return Kilocalories, nil
+	case "kj", "kilojoules":
+		return Kilojoules, nil
+	}
+	return "", fmt.Errorf("unknown unit %q", s)
+}
+
+// toKilograms converts a weight in unit to kilograms.
+func toKilograms(value float64, unit Unit) (float64, error) {
+	switch unit {
+	case Kilograms, "":
+		return value, nil
+	case Grams:
+		return value / 1000, nil
+	case Pounds:
+		return value * 0.45359237, nil
+	case Stones:
+		return value * 6.35029318, nil
+	}
+	return 0, fmt.Errorf("%s is not a unit of weight", unit)
+}
+
+// toKilocalories converts an energy in unit to kilocalories.
+func toKilocalories(value float64, unit Unit) (float64, error) {
+	switch unit {
+	case Kilocalories, "":
+		return value, nil
+	case Calories:
+		return value / 1000, nil
+	case Kilojoules:
+		return value / 4.184, nil
+	}
+	return 0, fmt.Errorf("%s is not a unit of energy", unit)
+}
+
+// dayAggregator collects samples into calendar days. A day's weight is its earliest weigh-in, which
+// is normally the morning weight before eating, and its calories are the sum of everything logged.
+type dayAggregator struct {
+	loc  *time.Location
+	days map[time.Time]*aggregateDay
+}
+
+type aggregateDay struct {
+	weighedAt time.Time
+	weight    *float64
+	calories  *float64
+}
+
+func newDayAggregator(loc *time.Location) *dayAggregator {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &dayAggregator{loc: loc, days: map[time.Time]*aggregateDay{}}
+}
+
+func (a *dayAggregator) day(t time.Time) *aggregateDay {
+	t = t.In(a.loc)
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	day, ok := a.days[date]
+	if !ok {
+		day = &aggregateDay{}
+		a.days[date] = day
+	}
+	return day
+}
+
+func (a *dayAggregator) addWeight(t time.Time, kg float64) {
+	day := a.day(t)
+	if day.weight == nil || t.Before(day.weighedAt) {
+		day.weighedAt = t
+		day.weight = &kg
+	}
+}
+
+func (a *dayAggregator) addCalories(t time.Time, kcal float64) {
+	day := a.day(t)
+	if day.calories == nil {
+		day.calories = new(float64)
+	}
+	*day.calories += kcal
+}
+
+// rows returns the aggregated days in date order.
+func (a *dayAggregator) rows() []Row {
+	rows := make([]Row, 0, len(a.days))
+	for date, day := range a.days {
+		rows = append(rows, Row{Date: date, Weight: day.weight, Calories: day.calories})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Date.Before(rows[j].Date)
+	})
+	return rows
+}
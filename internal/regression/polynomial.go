@@ -0,0 +1,128 @@
+package regression
+
+import (
+	"errors"
+	"math"
+)
+
+// Polynomial holds the coefficients of a polynomial in x, lowest power first.
+type Polynomial []float64
+
+// Eval returns the value of p at x.
+func (p Polynomial) Eval(x float64) float64 {
+	var value float64
+	for i := len(p) - 1; i >= 0; i-- {
+		value = value*x + p[i]
+	}
+	return value
+}
+
+// errSingular is returned when the coordinates cannot determine every coefficient, such as a quadratic through
+// coordinates at only two distinct x.
+var errSingular = errors.New("regression: not enough distinct x values to fit a polynomial of that degree")
+
+// WLSPolynomial fits a polynomial of the given degree to coords by weighted least squares.
+func WLSPolynomial(coords []Coord, weights []float64, degree int) (Polynomial, error) {
+	if len(weights) != len(coords) {
+		return nil, errors.New("regression: wls polynomial requires coordinate and weight slices of equal length")
+	}
+	if degree < 0 {
+		return nil, errors.New("regression: the polynomial degree must not be negative")
+	}
+
+	// Fit around the middle of the x values, scaled to about ±1, to keep the normal equations well conditioned.
+	var sumWeights, sumX float64
+	for i := 0; i < len(coords); i++ {
+		sumWeights = sumWeights + weights[i]
+		sumX = sumX + weights[i]*coords[i].X
+	}
+	origin := sumX / sumWeights
+	var scale float64
+	for i := 0; i < len(coords); i++ {
+		scale = math.Max(scale, findDist(coords[i].X, origin))
+	}
+	if scale == 0 {
+		scale = 1
+	}
+
+	scaled, err := fitPolynomial(coords, weights, degree, origin, scale)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expand each a*((x-origin)/scale)^k back into powers of x.
+	poly := make(Polynomial, degree+1)
+	for k, a := range scaled {
+		term := a / math.Pow(scale, float64(k))
+		binomial := 1.0
+		for j := k; j >= 0; j-- {
+			poly[j] = poly[j] + term*binomial*math.Pow(-origin, float64(k-j))
+			binomial = binomial * float64(j) / float64(k-j+1)
+		}
+	}
+	return poly, nil
+}
+
+// fitPolynomial fits a polynomial of the given degree in (x-origin)/scale by weighted least squares, returning
+// its coefficients lowest power first.
+func fitPolynomial(coords CoordSlice, weights []float64, degree int, origin float64, scale float64) ([]float64, error) {
+	size := degree + 1
+
+	// The normal equations: sums of w*u^(j+k) on the left and w*y*u^j on the right.
+	powerSums := make([]float64, 2*size-1)
+	matrix := make([][]float64, size)
+	for j := range matrix {
+		matrix[j] = make([]float64, size+1)
+	}
+	for i := 0; i < len(coords); i++ {
+		u := (coords[i].X - origin) / scale
+		power := weights[i]
+		for p := range powerSums {
+			if p < size {
+				matrix[p][size] = matrix[p][size] + power*coords[i].Y
+			}
+			powerSums[p] = powerSums[p] + power
+			power = power * u
+		}
+	}
+	for j := 0; j < size; j++ {
+		for k := 0; k < size; k++ {
+			matrix[j][k] = powerSums[j+k]
+		}
+	}
+	if !(powerSums[0] > 0) {
+		return nil, errors.New("regression: the weights must sum to more than zero")
+	}
+
+	// Gaussian elimination with partial pivoting. As u is within about ±1 no pivot should be tiny next to the
+	// sum of the weights unless the x values cannot determine the polynomial.
+	tolerance := 1e-10 * powerSums[0]
+	for col := 0; col < size; col++ {
+		pivot := col
+		for row := col + 1; row < size; row++ {
+			if math.Abs(matrix[row][col]) > math.Abs(matrix[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(matrix[pivot][col]) <= tolerance {
+			return nil, errSingular
+		}
+		matrix[col], matrix[pivot] = matrix[pivot], matrix[col]
+		for row := col + 1; row < size; row++ {
+			factor := matrix[row][col] / matrix[col][col]
+			for k := col; k <= size; k++ {
+				matrix[row][k] = matrix[row][k] - factor*matrix[col][k]
+			}
+		}
+	}
+
+	coefficients := make([]float64, size)
+	for row := size - 1; row >= 0; row-- {
+		sum := matrix[row][size]
+		for k := row + 1; k < size; k++ {
+			sum = sum - matrix[row][k]*coefficients[k]
+		}
+		coefficients[row] = sum / matrix[row][row]
+	}
+	return coefficients, nil
+}
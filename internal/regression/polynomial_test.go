@@ -0,0 +1,75 @@
+package regression
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPolynomialEval(t *testing.T) {
+	p := Polynomial{1, -2, 3}
+	if value := p.Eval(2); value != 9 {
+		t.Errorf("Eval returned %v not %v", value, 9)
+	}
+	if value := (Polynomial{}).Eval(2); value != 0 {
+		t.Errorf("Eval returned %v not %v", value, 0)
+	}
+}
+
+func TestWLSPolynomial(t *testing.T) {
+	correct := Polynomial{2, -3, 0.5, 0.25}
+	var coords []Coord
+	var weights []float64
+	for x := -3.0; x <= 6; x++ {
+		coords = append(coords, Coord{X: x, Y: correct.Eval(x)})
+		weights = append(weights, 1+math.Mod(x*x, 3))
+	}
+
+	for degree := 1; degree <= 3; degree++ {
+		poly, err := WLSPolynomial(coords, weights, degree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(poly) != degree+1 {
+			t.Fatalf("WLSPolynomial returned %v coefficients not %v", len(poly), degree+1)
+		}
+		if degree < 3 {
+			continue
+		}
+		for i := range correct {
+			if math.Abs(poly[i]-correct[i]) > 1e-9 {
+				t.Errorf("WLSPolynomial returned coefficient %v of %v not %v", i, poly[i], correct[i])
+			}
+		}
+	}
+}
+
+func TestWLSPolynomialFarFromZero(t *testing.T) {
+	// Day offsets several years in, where powers of x are large.
+	correct := func(x float64) float64 { return 80 + 0.01*(x-2000)*(x-2000) }
+	var coords []Coord
+	var weights []float64
+	for x := 1980.0; x <= 2020; x++ {
+		coords = append(coords, Coord{X: x, Y: correct(x)})
+		weights = append(weights, 1)
+	}
+
+	poly, err := WLSPolynomial(coords, weights, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []float64{1980, 2000, 2013.5} {
+		if math.Abs(poly.Eval(x)-correct(x)) > 1e-6 {
+			t.Errorf("WLSPolynomial evaluated to %v not %v at %v", poly.Eval(x), correct(x), x)
+		}
+	}
+}
+
+func TestWLSPolynomialSingular(t *testing.T) {
+	coords := []Coord{{X: 1, Y: 2}, {X: 1, Y: 3}, {X: 2, Y: 4}}
+	if _, err := WLSPolynomial(coords, []float64{1, 1, 1}, 2); err == nil {
+		t.Error("WLSPolynomial fitted a quadratic through two distinct x values")
+	}
+	if _, err := WLSPolynomial(coords, []float64{1, 1}, 1); err == nil {
+		t.Error("WLSPolynomial accepted mismatched weights")
+	}
+}
@@ -2,9 +2,9 @@ package regression
 
 import (
 	"errors"
-	"fmt"
 	"math"
 	"sort"
+	"sync"
 )
 
 type Coord struct {
@@ -26,25 +26,6 @@ func (s CoordSlice) Less(i, j int) bool {
 	return s[i].X < s[j].X
 }
 
-type coordDist struct {
-	coord Coord
-	dist  float64
-}
-
-type coordDistSlice []coordDist
-
-func (s coordDistSlice) Len() int {
-	return len(s)
-}
-
-func (s coordDistSlice) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func (s coordDistSlice) Less(i, j int) bool {
-	return s[i].dist < s[j].dist
-}
-
 func CoordsToArrays(coords []Coord) ([]float64, []float64) {
 	var xCoords []float64
 	var yCoords []float64
@@ -70,127 +51,351 @@ func findMax(values []float64) float64 {
 	return max
 }
 
-func findNearest(sortedCoords CoordSlice, targetX float64, bandwidth float64) (coordDistSlice, error) {
+// window is the run of sorted coordinates used for the local fit at one estimation point.
+type window struct {
+	// start is the index of the first coordinate in the window, end is one past the last.
+	start, end int
+	// maxDist is the distance from the estimation point to the furthest coordinate in the window.
+	maxDist float64
+}
 
-	if bandwidth <= 0 || bandwidth > 1 {
-		return nil, errors.New("findnearest: the bandwidth must be >0 and <=1")
-	}
+// windowFinder slides a window along sorted coordinates as it is given increasing estimation points, so that
+// each coordinate enters and leaves the window at most once.
+type windowFinder struct {
+	sortedCoords CoordSlice
+	halfWidth    float64
+	neighbours   int
+	start, end   int
+}
 
-	sort.Sort(sortedCoords)
+func newWindowFinder(sortedCoords CoordSlice, opts *loessOptions) *windowFinder {
 	totalWidth := sortedCoords[len(sortedCoords)-1].X - sortedCoords[0].X
-	windowWidth := bandwidth * totalWidth
-	minX := targetX - windowWidth/2
-	maxX := targetX + windowWidth/2
-
-	var distances coordDistSlice
-	for i := 0; i < len(sortedCoords); i++ {
-		if sortedCoords[i].X >= minX && sortedCoords[i].X <= maxX {
-			distances = append(distances, coordDist{
-				coord: sortedCoords[i],
-				dist:  findDist(targetX, sortedCoords[i].X),
-			},
-			)
-		}
+	finder := &windowFinder{
+		sortedCoords: sortedCoords,
+		halfWidth:    opts.bandwidth * totalWidth / 2,
+		neighbours:   opts.neighbours,
+	}
+	if finder.neighbours > len(sortedCoords) {
+		finder.neighbours = len(sortedCoords)
 	}
+	if finder.neighbours > 0 {
+		finder.end = finder.neighbours
+	}
+	return finder
+}
 
-	sort.Sort(distances)
+// next returns the window for targetX, which must not be less than the previous targetX.
+func (f *windowFinder) next(targetX float64) window {
+	coords := f.sortedCoords
+	if f.neighbours > 0 {
+		// Slide the fixed size window right while that brings a nearer coordinate in than it drops.
+		for f.end < len(coords) && targetX-coords[f.start].X > coords[f.end].X-targetX {
+			f.start++
+			f.end++
+		}
+	} else {
+		for f.start < len(coords) && coords[f.start].X < targetX-f.halfWidth {
+			f.start++
+		}
+		if f.end < f.start {
+			f.end = f.start
+		}
+		for f.end < len(coords) && coords[f.end].X <= targetX+f.halfWidth {
+			f.end++
+		}
+	}
 
-	return distances, nil
+	w := window{start: f.start, end: f.end}
+	if w.start < w.end {
+		w.maxDist = math.Max(findDist(targetX, coords[w.start].X), findDist(targetX, coords[w.end-1].X))
+	}
+	return w
 }
 
-func tricubeWeightFunction(sortedCoordDists coordDistSlice) []float64 {
+func tricubeWeightFunction(coords CoordSlice, targetX float64, maxDist float64, weights []float64) {
 	//https://uk.mathworks.com/help/curvefit/smoothing-data.html
 
-	weights := make([]float64, len(sortedCoordDists))
-	maxDist := sortedCoordDists[len(sortedCoordDists)-1].dist
-
-	for i := 0; i < len(sortedCoordDists); i++ {
-		weights[i] = math.Pow(1-math.Pow(math.Abs(sortedCoordDists[i].dist/maxDist), 3), 3)
+	for i := 0; i < len(coords); i++ {
+		// A window of coordinates all at the estimation point weights them equally.
+		if maxDist == 0 {
+			weights[i] = 1
+			continue
+		}
+		u := findDist(coords[i].X, targetX) / maxDist
+		u = 1 - u*u*u
+		weights[i] = u * u * u
 	}
-	return weights
 }
 
-func weightedMean(values []float64, weights []float64) (float64, error) {
-	if len(weights) != len(values) {
-		return 0, errors.New("regression: weighted mean requires equal length weight and value slices")
+func wLSRegression(coords CoordSlice, weights []float64) (float64, float64, error) {
+	if len(weights) != len(coords) {
+		return 0, 0, errors.New("regression: wls regressions requires coordinate and weight slices of equal length")
 	}
 
-	var sumWeights float64
-	for i := 0; i < len(weights); i++ {
+	var sumWeights, sumX, sumY float64
+	for i := 0; i < len(coords); i++ {
 		sumWeights = sumWeights + weights[i]
+		sumX = sumX + weights[i]*coords[i].X
+		sumY = sumY + weights[i]*coords[i].Y
+	}
+	weightedMeanX := sumX / sumWeights
+	weightedMeanY := sumY / sumWeights
+
+	var sumNumerator float64
+	var sumDenominator float64
+	for i := 0; i < len(coords); i++ {
+		dx := coords[i].X - weightedMeanX
+		sumNumerator = sumNumerator + weights[i]*dx*(coords[i].Y-weightedMeanY)
+		sumDenominator = sumDenominator + weights[i]*dx*dx
 	}
 
-	var sumWeightedValues float64
-	for i := 0; i < len(values); i++ {
-		sumWeightedValues = sumWeightedValues + values[i]*weights[i]
+	// With all the weight on a single x there is no slope to fit, so fall back to the weighted mean.
+	var slope float64
+	if sumDenominator != 0 {
+		slope = sumNumerator / sumDenominator
 	}
+	var intercept = weightedMeanY - slope*weightedMeanX
 
-	return sumWeightedValues / sumWeights, nil
+	return slope, intercept, nil
 }
 
-func wLSRegression(coordinates coordDistSlice, weights []float64) (float64, float64, error) {
-	if len(weights) != len(coordinates) {
-		return 0, 0, errors.New("regression: wls regressions requires coordinate and weight slices of equal length")
+// Option configures CalcLOESS.
+type Option func(*loessOptions)
+
+type loessOptions struct {
+	bandwidth  float64
+	neighbours int
+	iterations int
+	workers    int
+	degree     int
+}
+
+// RobustIterations makes CalcLOESS refit n times, each time downweighting coordinates with large residuals
+// using Cleveland's bisquare weights, so that outliers do not drag the curve towards them.
+// R's lowess uses 3 iterations.
+func RobustIterations(n int) Option {
+	return func(o *loessOptions) {
+		o.iterations = n
 	}
+}
 
-	var xCoords []float64
-	var yCoords []float64
+// Neighbours sizes each local fit by the n coordinates nearest the estimation point, as R's lowess does,
+// instead of by the bandwidth's fraction of the x range. The furthest of the n gets no weight.
+func Neighbours(n int) Option {
+	return func(o *loessOptions) {
+		o.neighbours = n
+	}
+}
 
-	for i := 0; i < len(coordinates); i++ {
-		xCoords = append(xCoords, coordinates[i].coord.X)
-		yCoords = append(yCoords, coordinates[i].coord.Y)
+// Degree sets the degree of the polynomial fitted in each window: 1 for straight lines, the default, or 2 for
+// quadratics, which follow peaks and troughs more closely.
+func Degree(degree int) Option {
+	return func(o *loessOptions) {
+		o.degree = degree
 	}
+}
 
-	weightedMeanX, err := weightedMean(xCoords, weights)
-	weightedMeanY, err := weightedMean(yCoords, weights)
+// Workers splits the estimation points between n goroutines. Each local fit is independent, so the result is
+// the same as evaluating them in turn.
+func Workers(n int) Option {
+	return func(o *loessOptions) {
+		o.workers = n
+	}
+}
 
-	if err != nil {
-		fmt.Println(err)
+// fit estimates the value at targetX from a weighted regression of the given degree over w. robustness holds a
+// weight for each of sortedCoords, or is nil before the first robustness iteration. weights is scratch space at
+// least as long as w.
+func fit(sortedCoords CoordSlice, w window, targetX float64, degree int, robustness []float64, weights []float64) float64 {
+	if w.start == w.end {
+		return math.NaN()
+	}
+	coords := sortedCoords[w.start:w.end]
+	weights = weights[:len(coords)]
+	tricubeWeightFunction(coords, targetX, w.maxDist, weights)
+
+	if robustness != nil {
+		var sumWeights float64
+		for i := range coords {
+			sumWeights = sumWeights + weights[i]*robustness[w.start+i]
+		}
+		// If every coordinate in the window is an outlier, keep the plain fit rather than dividing by zero.
+		if sumWeights > 0 {
+			for i := range coords {
+				weights[i] = weights[i] * robustness[w.start+i]
+			}
+		}
 	}
 
-	var sumNumerator float64
-	var sumDenominator float64
-	for i := 0; i < len(xCoords); i++ {
-		sumNumerator = sumNumerator + weights[i]*(xCoords[i]-weightedMeanX)*(yCoords[i]-weightedMeanY)
-		sumDenominator = sumDenominator + weights[i]*math.Pow(xCoords[i]-weightedMeanX, 2)
+	if degree == 1 {
+		slope, intercept, _ := wLSRegression(coords, weights)
+		return slope*targetX + intercept
 	}
 
-	var slope = sumNumerator / sumDenominator
-	var intercept = weightedMeanY - slope*weightedMeanX
+	// Centred on targetX the fitted polynomial's constant term is the estimate. When the window has too few
+	// distinct x values for the degree, fit a lower degree instead.
+	scale := w.maxDist
+	if scale == 0 {
+		scale = 1
+	}
+	for ; degree >= 0; degree-- {
+		coefficients, err := fitPolynomial(coords, weights, degree, targetX, scale)
+		if err == nil {
+			return coefficients[0]
+		}
+		if err != errSingular {
+			break
+		}
+	}
+	return math.NaN()
+}
 
-	return slope, intercept, nil
+// fitSorted estimates the curve at each of targets, which must be in ascending order, into estimates.
+func fitSorted(sortedCoords CoordSlice, opts *loessOptions, targets []float64, robustness []float64, estimates []float64) {
+	finder := newWindowFinder(sortedCoords, opts)
+	weights := make([]float64, len(sortedCoords))
+	for i, targetX := range targets {
+		estimates[i] = fit(sortedCoords, finder.next(targetX), targetX, opts.degree, robustness, weights)
+	}
+}
+
+// fitAll estimates the curve at each of targets, which must be in ascending order, splitting them into
+// contiguous runs for the configured number of workers.
+func fitAll(sortedCoords CoordSlice, opts *loessOptions, targets []float64, robustness []float64) []float64 {
+	estimates := make([]float64, len(targets))
+	workers := opts.workers
+	if workers > len(targets) {
+		workers = len(targets)
+	}
+	if workers <= 1 {
+		fitSorted(sortedCoords, opts, targets, robustness, estimates)
+		return estimates
+	}
+
+	var wg sync.WaitGroup
+	chunk := (len(targets) + workers - 1) / workers
+	for start := 0; start < len(targets); start += chunk {
+		end := start + chunk
+		if end > len(targets) {
+			end = len(targets)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			fitSorted(sortedCoords, opts, targets[start:end], robustness, estimates[start:end])
+		}(start, end)
+	}
+	wg.Wait()
+	return estimates
 }
 
-func CalcLOESS(estimationPoints []float64, coordinates []Coord, bandwidth float64) ([]Coord, error) {
-	var loessPoints []Coord
+// bisquareWeights returns the robustness weight of each residual: 1 for a perfect fit, falling to 0 at six times
+// the median absolute residual. It returns false when the residuals are too small to be worth reweighting.
+func bisquareWeights(residuals []float64) ([]float64, bool) {
+	absResiduals := make([]float64, len(residuals))
+	var sumResiduals float64
+	for i, residual := range residuals {
+		absResiduals[i] = math.Abs(residual)
+		sumResiduals = sumResiduals + absResiduals[i]
+	}
+	sorted := make([]float64, len(absResiduals))
+	copy(sorted, absResiduals)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	median := sorted[middle]
+	if len(sorted)%2 == 0 {
+		median = (sorted[middle-1] + sorted[middle]) / 2
+	}
+	scale := 6 * median
+	if scale < 1e-7*sumResiduals/float64(len(residuals)) {
+		return nil, false
+	}
 
+	weights := make([]float64, len(residuals))
+	for i, residual := range absResiduals {
+		switch {
+		case residual <= 0.001*scale:
+			weights[i] = 1
+		case residual <= 0.999*scale:
+			weights[i] = math.Pow(1-math.Pow(residual/scale, 2), 2)
+		}
+	}
+	return weights, true
+}
+
+// CalcLOESS estimates the curve through coordinates at each of estimationPoints using locally weighted linear
+// regression, or quadratic with Degree(2), over windows spanning bandwidth of the x range. Estimation points
+// with no coordinates in their window are NaN.
+//
+// The coordinates and estimation points are each sorted once, and the window is slid along the coordinates
+// from one estimation point to the next, so the cost grows with the number of points times the window size.
+func CalcLOESS(estimationPoints []float64, coordinates []Coord, bandwidth float64, options ...Option) ([]Coord, error) {
 	if bandwidth <= 0 || bandwidth > 1 {
 		return nil, errors.New("CalcLOESS: the bandwidth must be >0 and <=1")
 	}
+	if len(coordinates) == 0 {
+		return nil, errors.New("CalcLOESS: at least one coordinate is required")
+	}
 
-	// For each estimation point, calculate WLS regression line from nearest coordinates, then evaluate.
-	for i := 0; i < len(estimationPoints); i++ {
-		var widthCoords coordDistSlice
+	opts := loessOptions{bandwidth: bandwidth, degree: 1}
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.iterations < 0 {
+		return nil, errors.New("CalcLOESS: the number of robust iterations must not be negative")
+	}
+	if opts.neighbours < 0 || opts.neighbours == 1 {
+		return nil, errors.New("CalcLOESS: at least two neighbours are required")
+	}
+	if opts.degree != 1 && opts.degree != 2 {
+		return nil, errors.New("CalcLOESS: the degree must be 1 or 2")
+	}
+	if opts.workers < 0 {
+		return nil, errors.New("CalcLOESS: the number of workers must not be negative")
+	}
 
-		// Capture coordinates within the width
-		widthCoords, err := findNearest(coordinates, estimationPoints[i], bandwidth)
+	sortedCoords := make(CoordSlice, len(coordinates))
+	copy(sortedCoords, coordinates)
+	sort.Sort(sortedCoords)
+	sortedX, _ := CoordsToArrays(sortedCoords)
+
+	// Each robustness iteration refits at every coordinate and reweights them by how far they sit from the fit.
+	var robustness []float64
+	for iteration := 0; iteration < opts.iterations; iteration++ {
+		fitted := fitAll(sortedCoords, &opts, sortedX, robustness)
+		residuals := make([]float64, len(sortedCoords))
+		for i := 0; i < len(sortedCoords); i++ {
+			residuals[i] = sortedCoords[i].Y - fitted[i]
+		}
 
-		weights := tricubeWeightFunction(widthCoords)
-		slope, intercept, err := wLSRegression(widthCoords, weights)
-		if err != nil {
-			fmt.Println(err)
+		weights, ok := bisquareWeights(residuals)
+		if !ok {
+			break
 		}
+		robustness = weights
+	}
 
-		//fmt.Println("Weights:", weights)
-		//fmt.Println("Slope: ", slope)
-		//fmt.Println("Intercept: ", intercept)
+	// Evaluate the estimation points in ascending order, then return them in the order they were given.
+	order := make([]int, len(estimationPoints))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return estimationPoints[order[i]] < estimationPoints[order[j]]
+	})
+	targets := make([]float64, len(order))
+	for i, index := range order {
+		targets[i] = estimationPoints[index]
+	}
+	estimates := fitAll(sortedCoords, &opts, targets, robustness)
 
-		estimatedValue := slope*estimationPoints[i] + intercept
-		//fmt.Println("\033[0;92m", estimatedValue, "\033[0m")
-		loessPoints = append(loessPoints, Coord{
-			X: estimationPoints[i],
-			Y: estimatedValue,
-		})
+	loessPoints := make([]Coord, len(estimationPoints))
+	for i, index := range order {
+		loessPoints[index] = Coord{
+			X: estimationPoints[index],
+			Y: estimates[i],
+		}
 	}
 
 	return loessPoints, nil
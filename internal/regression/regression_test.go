@@ -2,6 +2,9 @@ package regression
 
 import (
 	"math"
+	"math/rand"
+	"runtime"
+	"sort"
 	"testing"
 )
 
@@ -257,8 +260,16 @@ func TestCalcLOESS(t *testing.T) {
 		20.593, 107.160, 139.767, 174.263, 207.233, 216.662, 220.544, 229.861, 229.835, 229.430, 226.604, 220.390, 172.348, 163.842, 161.849, 160.335, 160.192, 161.056, 227.340, 227.899, 231.559,
 	}
 
-	loessPoints := CalcLOESS(values, 6)
+	// The old answers were computed from windows of the seven nearest coordinates, like R's lowess(f = 1/3).
+	xPoints, _ := CoordsToArrays(values)
+	loessPoints, err := CalcLOESS(xPoints, values, 1, Neighbours(7))
+	if err != nil {
+		t.Fatal(err)
+	}
 	_, yPoints := CoordsToArrays(loessPoints)
+	if len(yPoints) != len(answers) {
+		t.Fatalf("Loess returned %v points not %v", len(yPoints), len(answers))
+	}
 
 	for i := 0; i < len(yPoints); i++ {
 		if math.Round(yPoints[i]*1000)/1000 != answers[i] {
@@ -267,3 +278,255 @@ func TestCalcLOESS(t *testing.T) {
 	}
 
 }
+
+// carsSpeed and carsDist are R's cars dataset.
+var carsSpeed = []float64{4, 4, 7, 7, 8, 9, 10, 10, 10, 11, 11, 12, 12, 12, 12, 13, 13, 13, 13, 14, 14, 14, 14, 15, 15, 15, 16, 16, 17, 17, 17, 18, 18, 18, 18, 19, 19, 19, 20, 20, 20, 20, 20, 22, 23, 24, 24, 24, 24, 25}
+var carsDist = []float64{2, 10, 4, 22, 16, 10, 18, 26, 34, 17, 28, 14, 20, 24, 28, 26, 34, 34, 46, 26, 36, 60, 80, 20, 26, 54, 32, 40, 32, 40, 50, 42, 56, 76, 84, 36, 46, 68, 32, 48, 52, 56, 64, 66, 54, 70, 92, 93, 120, 85}
+
+func TestCalcLOESSMatchesLowess(t *testing.T) {
+	var coordinates []Coord
+	for i := range carsSpeed {
+		coordinates = append(coordinates, Coord{X: carsSpeed[i], Y: carsDist[i]})
+	}
+
+	tests := []struct {
+		name       string
+		iterations int
+		// answers are the output of lowess(cars, iter = iterations) in R.
+		answers []float64
+	}{
+		{
+			name:       "no iterations",
+			iterations: 0,
+			answers: []float64{
+				3.443864, 3.443864, 12.784337, 12.784337, 15.952393, 19.096756, 22.187105, 22.187105, 22.187105, 25.605743,
+				25.605743, 29.363153, 29.363153, 29.363153, 29.363153, 32.915844, 32.915844, 32.915844, 32.915844, 36.558788,
+				36.558788, 36.558788, 36.558788, 41.103033, 41.103033, 41.103033, 45.021073, 45.021073, 47.363204, 47.363204,
+				47.363204, 49.565262, 49.565262, 49.565262, 49.565262, 52.904601, 52.904601, 52.904601, 59.411096, 59.411096,
+				59.411096, 59.411096, 59.411096, 71.215710, 77.029829, 82.975448, 82.975448, 82.975448, 82.975448, 89.127515,
+			},
+		},
+		{
+			name:       "three iterations",
+			iterations: 3,
+			answers: []float64{
+				4.965459, 4.965459, 13.124495, 13.124495, 15.858633, 18.579691, 21.280313, 21.280313, 21.280313, 24.129277,
+				24.129277, 27.119549, 27.119549, 27.119549, 27.119549, 30.027276, 30.027276, 30.027276, 30.027276, 32.962506,
+				32.962506, 32.962506, 32.962506, 36.757728, 36.757728, 36.757728, 40.435075, 40.435075, 43.463492, 43.463492,
+				43.463492, 46.885479, 46.885479, 46.885479, 46.885479, 50.793152, 50.793152, 50.793152, 56.491224, 56.491224,
+				56.491224, 56.491224, 56.491224, 67.585824, 73.079695, 78.643164, 78.643164, 78.643164, 78.643164, 84.328698,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// lowess's default span of 2/3 of the 50 points.
+			loessPoints, err := CalcLOESS(carsSpeed, coordinates, 1, Neighbours(33), RobustIterations(test.iterations))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, yPoints := CoordsToArrays(loessPoints)
+			for i := range yPoints {
+				if math.Abs(yPoints[i]-test.answers[i]) > 1e-5 {
+					t.Errorf("Loess returned %v not %v at speed %v", yPoints[i], test.answers[i], carsSpeed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCalcLOESSRobustIgnoresOutlier(t *testing.T) {
+	// A steady loss of 0.1kg a day with 0.2kg of day to day noise and a 2kg spike on day 15.
+	var coordinates []Coord
+	var xPoints []float64
+	for day := 0; day < 30; day++ {
+		weight := 80 - 0.1*float64(day) + 0.2*math.Pow(-1, float64(day))
+		if day == 15 {
+			weight += 2
+		}
+		coordinates = append(coordinates, Coord{X: float64(day), Y: weight})
+		xPoints = append(xPoints, float64(day))
+	}
+
+	robustPoints, err := CalcLOESS(xPoints, coordinates, 0.3, RobustIterations(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainPoints, err := CalcLOESS(xPoints, coordinates, 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trend := 80 - 0.1*15
+	if diff := math.Abs(robustPoints[15].Y - trend); diff > 0.15 {
+		t.Errorf("Robust loess returned %v on the spike not %v", robustPoints[15].Y, trend)
+	}
+	if diff := plainPoints[15].Y - trend; diff < 0.3 {
+		t.Errorf("Loess returned %v on the spike, expected the spike to pull it above %v", plainPoints[15].Y, trend)
+	}
+}
+
+func TestCalcLOESSRejectsBadOptions(t *testing.T) {
+	coordinates := []Coord{{X: 0, Y: 1}, {X: 1, Y: 2}, {X: 2, Y: 3}}
+	if _, err := CalcLOESS([]float64{1}, coordinates, 0.5, RobustIterations(-1)); err == nil {
+		t.Error("CalcLOESS accepted a negative number of iterations")
+	}
+	if _, err := CalcLOESS([]float64{1}, coordinates, 0.5, Neighbours(1)); err == nil {
+		t.Error("CalcLOESS accepted a single neighbour")
+	}
+	if _, err := CalcLOESS([]float64{1}, coordinates, 0.5, Degree(3)); err == nil {
+		t.Error("CalcLOESS accepted a cubic fit")
+	}
+}
+
+func TestCalcLOESSQuadratic(t *testing.T) {
+	// Cutting then bulking: weight falls to a low on day 30 and rises again.
+	correct := func(x float64) float64 { return 75 + 0.004*(x-30)*(x-30) }
+	var coordinates []Coord
+	var xPoints []float64
+	for day := 0.0; day <= 60; day++ {
+		coordinates = append(coordinates, Coord{X: day, Y: correct(day)})
+		xPoints = append(xPoints, day)
+	}
+
+	loessPoints, err := CalcLOESS(xPoints, coordinates, 0.4, Degree(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, point := range loessPoints {
+		if math.Abs(point.Y-correct(point.X)) > 1e-9 {
+			t.Errorf("Quadratic loess returned %v not %v on day %v", point.Y, correct(point.X), point.X)
+		}
+	}
+
+	linearPoints, err := CalcLOESS(xPoints, coordinates, 0.4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linearPoints[30].Y-correct(30) < 0.05 {
+		t.Errorf("Linear loess returned %v at the low, expected it to flatten the curve above %v", linearPoints[30].Y, correct(30))
+	}
+}
+
+func TestCalcLOESSQuadraticFewPoints(t *testing.T) {
+	// Windows with only two distinct x values fall back to a straight line.
+	coordinates := []Coord{{X: 0, Y: 1}, {X: 10, Y: 2}, {X: 20, Y: 3}}
+	loessPoints, err := CalcLOESS([]float64{5}, coordinates, 0.5, Degree(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(loessPoints[0].Y-1.5) > 1e-9 {
+		t.Errorf("Quadratic loess returned %v not %v", loessPoints[0].Y, 1.5)
+	}
+}
+
+func TestCalcLOESSEmptyWindow(t *testing.T) {
+	coordinates := []Coord{{X: 50, Y: 80}, {X: 51, Y: 79.9}, {X: 52, Y: 79.8}, {X: 53, Y: 79.7}}
+	loessPoints, err := CalcLOESS([]float64{0, 51.5}, coordinates, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(loessPoints[0].Y) {
+		t.Errorf("Loess returned %v not NaN far from any coordinate", loessPoints[0].Y)
+	}
+	if math.Abs(loessPoints[1].Y-79.85) > 1e-9 {
+		t.Errorf("Loess returned %v not %v", loessPoints[1].Y, 79.85)
+	}
+}
+
+// noisyCoords returns n daily weights following a slow cycle with noise, logged in a random order.
+func noisyCoords(n int) ([]float64, []Coord) {
+	random := rand.New(rand.NewSource(1))
+	xPoints := make([]float64, n)
+	coordinates := make([]Coord, n)
+	for i := 0; i < n; i++ {
+		xPoints[i] = float64(i)
+		coordinates[i] = Coord{X: float64(i), Y: 80 + 5*math.Sin(float64(i)/200) + random.NormFloat64()}
+	}
+	random.Shuffle(n, func(i, j int) {
+		coordinates[i], coordinates[j] = coordinates[j], coordinates[i]
+	})
+	return xPoints, coordinates
+}
+
+// naiveLOESS is the original CalcLOESS, which sorts and scans every coordinate for each estimation point.
+func naiveLOESS(estimationPoints []float64, coordinates []Coord, bandwidth float64) []float64 {
+	type coordDist struct {
+		coord Coord
+		dist  float64
+	}
+	estimates := make([]float64, len(estimationPoints))
+	for i, targetX := range estimationPoints {
+		sortedCoords := make(CoordSlice, len(coordinates))
+		copy(sortedCoords, coordinates)
+		sort.Sort(sortedCoords)
+		windowWidth := bandwidth * (sortedCoords[len(sortedCoords)-1].X - sortedCoords[0].X)
+
+		var distances []coordDist
+		for _, coord := range sortedCoords {
+			if coord.X >= targetX-windowWidth/2 && coord.X <= targetX+windowWidth/2 {
+				distances = append(distances, coordDist{coord: coord, dist: findDist(targetX, coord.X)})
+			}
+		}
+		sort.Slice(distances, func(i, j int) bool { return distances[i].dist < distances[j].dist })
+
+		maxDist := distances[len(distances)-1].dist
+		window := make(CoordSlice, len(distances))
+		weights := make([]float64, len(distances))
+		for j, distance := range distances {
+			window[j] = distance.coord
+			weights[j] = math.Pow(1-math.Pow(distance.dist/maxDist, 3), 3)
+		}
+		slope, intercept, _ := wLSRegression(window, weights)
+		estimates[i] = slope*targetX + intercept
+	}
+	return estimates
+}
+
+func TestCalcLOESSMatchesNaive(t *testing.T) {
+	xPoints, coordinates := noisyCoords(500)
+	want := naiveLOESS(xPoints, coordinates, 0.2)
+
+	for _, workers := range []int{1, 3} {
+		loessPoints, err := CalcLOESS(xPoints, coordinates, 0.2, Workers(workers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, point := range loessPoints {
+			if point.X != xPoints[i] || math.Abs(point.Y-want[i]) > 1e-9 {
+				t.Errorf("Loess with %v workers returned %v not %v at %v", workers, point, want[i], xPoints[i])
+			}
+		}
+	}
+}
+
+func BenchmarkCalcLOESS(b *testing.B) {
+	xPoints, coordinates := noisyCoords(10000)
+	benchmarks := []struct {
+		name    string
+		options []Option
+	}{
+		{"sequential", nil},
+		{"workers", []Option{Workers(runtime.NumCPU())}},
+		{"robust", []Option{RobustIterations(3)}},
+		{"neighbours", []Option{Neighbours(2000)}},
+	}
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := CalcLOESS(xPoints, coordinates, 0.2, benchmark.options...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNaiveLOESS is the baseline for BenchmarkCalcLOESS. Each iteration takes tens of seconds.
+func BenchmarkNaiveLOESS(b *testing.B) {
+	xPoints, coordinates := noisyCoords(10000)
+	for i := 0; i < b.N; i++ {
+		naiveLOESS(xPoints, coordinates, 0.2)
+	}
+}
@@ -0,0 +1,130 @@
+package regression
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Smoother estimates the underlying trend of noisy coordinates at each of estimationPoints.
+type Smoother interface {
+	Smooth(estimationPoints []float64, coordinates []Coord) ([]Coord, error)
+}
+
+// SmootherKind names a Smoother that can be chosen in the configuration.
+type SmootherKind string
+
+const (
+	// KindLOESS smooths with LOESS, which looks at coordinates on both sides of each point.
+	KindLOESS SmootherKind = "loess"
+	// KindEMA smooths with an exponential moving average, which only looks back.
+	KindEMA SmootherKind = "ema"
+	// KindKalman smooths with a local level Kalman filter, which only looks back.
+	KindKalman SmootherKind = "kalman"
+)
+
+// ParseSmootherKind returns the smoother kind named s.
+func ParseSmootherKind(s string) (SmootherKind, error) {
+	switch kind := SmootherKind(s); kind {
+	case KindLOESS, KindEMA, KindKalman:
+		return kind, nil
+	}
+	return "", fmt.Errorf("regression: unknown smoother %q, expected loess, ema or kalman", s)
+}
+
+// LOESS smooths with CalcLOESS. Each estimate depends on the coordinates after it, so the trend at a point
+// changes as later coordinates arrive.
+type LOESS struct {
+	Bandwidth float64
+	Options   []Option
+}
+
+// Smooth implements Smoother.
+func (l LOESS) Smooth(estimationPoints []float64, coordinates []Coord) ([]Coord, error) {
+	return CalcLOESS(estimationPoints, coordinates, l.Bandwidth, l.Options...)
+}
+
+// EMA is the exponentially smoothed moving average trend from The Hacker's Diet: each day the trend moves
+// Smoothing of the way towards that day's value. Across a gap of several days the trend moves as though every
+// missed day had the next value.
+type EMA struct {
+	// Smoothing is the fraction of the distance to a new value the trend moves per unit of x, between 0 and 1.
+	// The Hacker's Diet uses 0.1.
+	Smoothing float64
+}
+
+// Smooth implements Smoother. Each estimate only uses coordinates at or before its point, and points before
+// the first coordinate are NaN.
+func (e EMA) Smooth(estimationPoints []float64, coordinates []Coord) ([]Coord, error) {
+	if e.Smoothing <= 0 || e.Smoothing > 1 {
+		return nil, errors.New("EMA: the smoothing must be >0 and <=1")
+	}
+	if len(coordinates) == 0 {
+		return nil, errors.New("EMA: at least one coordinate is required")
+	}
+
+	sortedCoords := sortCoords(coordinates)
+	trend := make([]float64, len(sortedCoords))
+	trend[0] = sortedCoords[0].Y
+	for i := 1; i < len(sortedCoords); i++ {
+		step := sortedCoords[i].X - sortedCoords[i-1].X
+		alpha := 1 - math.Pow(1-e.Smoothing, step)
+		trend[i] = trend[i-1] + alpha*(sortedCoords[i].Y-trend[i-1])
+	}
+	return causalEstimates(estimationPoints, sortedCoords, trend), nil
+}
+
+// Kalman is a local level Kalman filter, which models each value as a hidden level plus measurement noise, with
+// the level taking a random walk between coordinates. Only the ratio of the two variances changes the trend.
+type Kalman struct {
+	// ProcessVariance is how much the level is expected to wander per unit of x.
+	ProcessVariance float64
+	// MeasurementVariance is the noise in each value around the level.
+	MeasurementVariance float64
+}
+
+// Smooth implements Smoother. Each estimate only uses coordinates at or before its point, and points before
+// the first coordinate are NaN.
+func (k Kalman) Smooth(estimationPoints []float64, coordinates []Coord) ([]Coord, error) {
+	if k.ProcessVariance < 0 || k.MeasurementVariance <= 0 {
+		return nil, errors.New("Kalman: the process variance must be >=0 and the measurement variance >0")
+	}
+	if len(coordinates) == 0 {
+		return nil, errors.New("Kalman: at least one coordinate is required")
+	}
+
+	sortedCoords := sortCoords(coordinates)
+	level := make([]float64, len(sortedCoords))
+	level[0] = sortedCoords[0].Y
+	variance := k.MeasurementVariance
+	for i := 1; i < len(sortedCoords); i++ {
+		// Predict: the level is unchanged but less certain the longer since the last coordinate.
+		variance = variance + k.ProcessVariance*(sortedCoords[i].X-sortedCoords[i-1].X)
+		// Update: move towards the new value in proportion to how uncertain the level is.
+		gain := variance / (variance + k.MeasurementVariance)
+		level[i] = level[i-1] + gain*(sortedCoords[i].Y-level[i-1])
+		variance = (1 - gain) * variance
+	}
+	return causalEstimates(estimationPoints, sortedCoords, level), nil
+}
+
+func sortCoords(coordinates []Coord) CoordSlice {
+	sortedCoords := make(CoordSlice, len(coordinates))
+	copy(sortedCoords, coordinates)
+	sort.Stable(sortedCoords)
+	return sortedCoords
+}
+
+// causalEstimates returns the trend of the last of sortedCoords at or before each estimation point.
+func causalEstimates(estimationPoints []float64, sortedCoords CoordSlice, trend []float64) []Coord {
+	estimates := make([]Coord, len(estimationPoints))
+	for i, x := range estimationPoints {
+		estimates[i] = Coord{X: x, Y: math.NaN()}
+		last := sort.Search(len(sortedCoords), func(j int) bool { return sortedCoords[j].X > x }) - 1
+		if last >= 0 {
+			estimates[i].Y = trend[last]
+		}
+	}
+	return estimates
+}
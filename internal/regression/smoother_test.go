@@ -0,0 +1,101 @@
+package regression
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEMA(t *testing.T) {
+	coordinates := []Coord{{X: 0, Y: 80}, {X: 1, Y: 81}, {X: 2, Y: 81}, {X: 3, Y: 79}, {X: 5, Y: 82}}
+	// The gap before x=5 moves the trend as far as two days of 82 would.
+	correct := []float64{80, 80.1, 80.19, 80.071, 80.071, 80.071*0.81 + 82*0.19}
+
+	estimates, err := EMA{Smoothing: 0.1}.Smooth([]float64{0, 1, 2, 3, 4, 5}, coordinates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, estimate := range estimates {
+		if math.Abs(estimate.Y-correct[i]) > 1e-9 {
+			t.Errorf("EMA returned %v not %v at %v", estimate.Y, correct[i], estimate.X)
+		}
+	}
+}
+
+func TestKalman(t *testing.T) {
+	// Without any process variance the level never moves, so the filter is the running mean.
+	coordinates := []Coord{{X: 0, Y: 80}, {X: 1, Y: 82}, {X: 2, Y: 81}, {X: 3, Y: 85}}
+	correct := []float64{80, 81, 81, 82}
+
+	estimates, err := Kalman{ProcessVariance: 0, MeasurementVariance: 0.5}.Smooth([]float64{0, 1, 2, 3}, coordinates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, estimate := range estimates {
+		if math.Abs(estimate.Y-correct[i]) > 1e-9 {
+			t.Errorf("Kalman returned %v not %v at %v", estimate.Y, correct[i], estimate.X)
+		}
+	}
+
+	// A large process variance trusts each new value.
+	estimates, err = Kalman{ProcessVariance: 1e9, MeasurementVariance: 0.5}.Smooth([]float64{3}, coordinates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(estimates[0].Y-85) > 1e-6 {
+		t.Errorf("Kalman returned %v not %v", estimates[0].Y, 85)
+	}
+}
+
+func TestSmoothersAreCausal(t *testing.T) {
+	coordinates := []Coord{{X: 1, Y: 80}, {X: 2, Y: 81}, {X: 3, Y: 79}}
+	later := append(append([]Coord(nil), coordinates...), Coord{X: 4, Y: 90})
+	points := []float64{0, 1, 2, 3}
+
+	smoothers := map[string]Smoother{
+		"EMA":    EMA{Smoothing: 0.1},
+		"Kalman": Kalman{ProcessVariance: 0.01, MeasurementVariance: 1},
+	}
+	for name, smoother := range smoothers {
+		before, err := smoother.Smooth(points, coordinates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := smoother.Smooth(points, later)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !math.IsNaN(before[0].Y) {
+			t.Errorf("%v returned %v not NaN before the first coordinate", name, before[0].Y)
+		}
+		for i := 1; i < len(points); i++ {
+			if before[i].Y != after[i].Y {
+				t.Errorf("%v returned %v at %v, which changed to %v with a later coordinate", name, before[i].Y, points[i], after[i].Y)
+			}
+		}
+	}
+}
+
+func TestSmoothersRejectBadParameters(t *testing.T) {
+	coordinates := []Coord{{X: 0, Y: 80}}
+	smoothers := map[string]Smoother{
+		"EMA":    EMA{Smoothing: 0},
+		"Kalman": Kalman{ProcessVariance: 0.01, MeasurementVariance: 0},
+		"LOESS":  LOESS{Bandwidth: 2},
+	}
+	for name, smoother := range smoothers {
+		if _, err := smoother.Smooth([]float64{0}, coordinates); err == nil {
+			t.Errorf("%v accepted bad parameters", name)
+		}
+	}
+}
+
+func TestParseSmootherKind(t *testing.T) {
+	for _, name := range []string{"loess", "ema", "kalman"} {
+		if kind, err := ParseSmootherKind(name); err != nil || string(kind) != name {
+			t.Errorf("ParseSmootherKind(%q) returned %v, %v", name, kind, err)
+		}
+	}
+	if _, err := ParseSmootherKind("spline"); err == nil {
+		t.Error("ParseSmootherKind accepted an unknown smoother")
+	}
+}
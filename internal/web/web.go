@@ -0,0 +1,416 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/api"
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	"git.ebain.es/healthAndFitnessTracker/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// LoginPath is where RequireSession sends visitors without a session.
+const LoginPath = "/login"
+
+const sessionTTL = 30 * 24 * time.Hour
+
+// loginCSRFCookie holds the CSRF token for the login form, which is submitted before there is a session
+// to keep one in.
+const loginCSRFCookie = "bt_login_csrf"
+
+// TableFunc renders the analysis table for a user as HTML.
+type TableFunc func(userID int64) (string, error)
+
+// Pages serves the server-rendered HTML interface: logging in, the table and the day forms.
+type Pages struct {
+	cfg       *config.Config
+	store     *database.Store
+	loc       *time.Location
+	table     TableFunc
+	templates map[string]*template.Template
+}
+
+// page is the data every template receives; Data holds the page-specific values.
+type page struct {
+	Title     string
+	User      *database.User
+	CSRFToken string
+	Data      interface{}
+}
+
+func NewPages(cfg *config.Config, store *database.Store, table TableFunc) (*Pages, error) {
+	p := &Pages{
+		cfg:       cfg,
+		store:     store,
+		loc:       cfg.Location(),
+		table:     table,
+		templates: make(map[string]*template.Template),
+	}
+
+	for _, name := range []string{"login", "table", "day_form", "confirm_delete", "error"} {
+		t, err := template.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+		if err != nil {
+			return nil, err
+		}
+		p.templates[name] = t
+	}
+	return p, nil
+}
+
+func (p *Pages) render(c *gin.Context, status int, name string, title string, data interface{}) {
+	pg := page{Title: title, Data: data}
+	if user, ok := api.UserFromContext(c); ok {
+		pg.User = &user
+		pg.CSRFToken = api.CurrentSession(c).CSRFToken
+	}
+
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := p.templates[name].ExecuteTemplate(c.Writer, "layout", pg); err != nil {
+		log.Print(err)
+	}
+}
+
+// RenderError shows an error page with the standard layout.
+func (p *Pages) RenderError(c *gin.Context, status int, message string) {
+	p.render(c, status, "error", http.StatusText(status), message)
+}
+
+type loginData struct {
+	Next      string
+	Username  string
+	Error     string
+	CSRFToken string
+}
+
+// LoginForm shows the login form, setting a cookie with the CSRF token the form must submit so another
+// site cannot log the browser in to an account of its choosing.
+func (p *Pages) LoginForm(c *gin.Context) {
+	token, err := c.Cookie(loginCSRFCookie)
+	if err != nil || token == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			log.Print(err)
+			p.RenderError(c, http.StatusInternalServerError, "The login form could not be shown.")
+			return
+		}
+		token = base64.RawURLEncoding.EncodeToString(random)
+		setLoginCSRFCookie(c, token, 0)
+	}
+	p.render(c, http.StatusOK, "login", "Log in", loginData{Next: c.Query("next"), CSRFToken: token})
+}
+
+func setLoginCSRFCookie(c *gin.Context, token string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     loginCSRFCookie,
+		Value:    token,
+		Path:     LoginPath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func (p *Pages) Login(c *gin.Context) {
+	username := c.PostForm("username")
+	next := c.PostForm("next")
+
+	token, err := c.Cookie(loginCSRFCookie)
+	if err != nil || token == "" || subtle.ConstantTimeCompare([]byte(c.PostForm(api.CSRFField)), []byte(token)) != 1 {
+		p.RenderError(c, http.StatusForbidden, "The login form has expired, please reload it and try again.")
+		return
+	}
+
+	user, err := p.store.Authenticate(username, c.PostForm("password"))
+	if errors.Is(err, database.ErrInvalidCredentials) {
+		p.render(c, http.StatusUnauthorized, "login", "Log in",
+			loginData{Next: next, Username: username, Error: "Incorrect username or password.", CSRFToken: token})
+		return
+	} else if err != nil {
+		log.Print(err)
+		p.RenderError(c, http.StatusInternalServerError, "Logging in failed.")
+		return
+	}
+
+	plaintext, session, err := p.store.CreateSession(user.ID, sessionTTL)
+	if err != nil {
+		log.Print(err)
+		p.RenderError(c, http.StatusInternalServerError, "Logging in failed.")
+		return
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     api.SessionCookie,
+		Value:    plaintext,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	setLoginCSRFCookie(c, "", -1)
+	c.Redirect(http.StatusSeeOther, safeRedirect(next))
+}
+
+// safeRedirect only allows local paths so the login form cannot be used to send users elsewhere.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/table"
+	}
+	return next
+}
+
+func (p *Pages) Logout(c *gin.Context) {
+	if cookie, err := c.Cookie(api.SessionCookie); err == nil {
+		if err := p.store.DeleteSession(cookie); err != nil {
+			log.Print(err)
+		}
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     api.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Redirect(http.StatusSeeOther, LoginPath)
+}
+
+func (p *Pages) Table(c *gin.Context) {
+	table, err := p.table(api.CurrentUser(c).ID)
+	if err != nil {
+		log.Print(err)
+		p.RenderError(c, http.StatusInternalServerError, "The table could not be generated.")
+		return
+	}
+	p.render(c, http.StatusOK, "table", "Progress", struct{ Table template.HTML }{template.HTML(table)})
+}
+
+type dayFormData struct {
+	Action   string
+	Date     string
+	Weight   string
+	Calories string
+	Errors   map[string]string
+}
+
+func formatValue(value float64, valid bool) string {
+	if !valid {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func newDayFormData(action string, record database.DayRecord) dayFormData {
+	return dayFormData{
+		Action:   action,
+		Date:     record.Time.Format(database.DateFormat),
+		Weight:   formatValue(record.Weight.Float64, record.Weight.Valid),
+		Calories: formatValue(record.Calories.Float64, record.Calories.Valid),
+	}
+}
+
+// NewDay shows the form for today, filled in with anything already logged today.
+func (p *Pages) NewDay(c *gin.Context) {
+	user := api.CurrentUser(c)
+	today := time.Now().In(api.UserLocation(user, p.loc))
+	record := database.DayRecord{Time: time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)}
+
+	existing, err := p.store.GetDayByDate(user.ID, record.Time)
+	if err == nil {
+		record = existing
+	} else if !errors.Is(err, database.ErrNotFound) {
+		log.Print(err)
+		p.RenderError(c, http.StatusInternalServerError, "The day could not be loaded.")
+		return
+	}
+
+	p.render(c, http.StatusOK, "day_form", "Log a day", newDayFormData("/days", record))
+}
+
+// parseDayForm validates a submitted day form with the same rules as the JSON API.
+func (p *Pages) parseDayForm(c *gin.Context) (database.DayRecord, dayFormData, bool) {
+	data := dayFormData{
+		Date:     strings.TrimSpace(c.PostForm("date")),
+		Weight:   strings.TrimSpace(c.PostForm("weight")),
+		Calories: strings.TrimSpace(c.PostForm("calories")),
+		Errors:   make(map[string]string),
+	}
+
+	date, err := time.Parse(database.DateFormat, data.Date)
+	if err != nil {
+		data.Errors["date"] = "must be a date"
+	} else {
+		today := time.Now().In(api.UserLocation(api.CurrentUser(c), p.loc))
+		if date.After(time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)) {
+			data.Errors["date"] = "must not be in the future"
+		}
+	}
+
+	weight, ok := parseOptionalFloat(data.Weight)
+	if !ok {
+		data.Errors["weight"] = "must be a number"
+	}
+	calories, ok := parseOptionalFloat(data.Calories)
+	if !ok {
+		data.Errors["calories"] = "must be a number"
+	}
+
+	if len(data.Errors) == 0 {
+		if err := api.ValidateDayValues(weight, calories); err != nil {
+			data.Errors = api.FieldErrors(err)
+		}
+	}
+	if len(data.Errors) > 0 {
+		return database.DayRecord{}, data, false
+	}
+
+	return api.NewDayRecord(date, weight, calories), data, true
+}
+
+func parseOptionalFloat(value string) (*float64, bool) {
+	if value == "" {
+		return nil, true
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &parsed, true
+}
+
+// measuredWeightError explains why the weight of a day with weigh-ins cannot be changed from the form.
+const measuredWeightError = "comes from the day's weigh-ins, delete them to change it"
+
+// CreateDay saves the new day form, merging into any record that already exists for the date.
+func (p *Pages) CreateDay(c *gin.Context) {
+	record, data, ok := p.parseDayForm(c)
+	if !ok {
+		data.Action = "/days"
+		p.render(c, http.StatusBadRequest, "day_form", "Log a day", data)
+		return
+	}
+
+	_, err := p.store.UpsertDay(api.CurrentUser(c).ID, record)
+	if errors.Is(err, database.ErrMeasuredWeight) {
+		data.Action = "/days"
+		data.Errors = map[string]string{"weight": measuredWeightError}
+		p.render(c, http.StatusConflict, "day_form", "Log a day", data)
+		return
+	} else if err != nil {
+		log.Print(err)
+		p.RenderError(c, http.StatusInternalServerError, "The day could not be saved.")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/table")
+}
+
+func (p *Pages) loadDay(c *gin.Context) (database.DayRecord, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		p.RenderError(c, http.StatusNotFound, "That day does not exist.")
+		return database.DayRecord{}, false
+	}
+
+	record, err := p.store.GetDay(api.CurrentUser(c).ID, id)
+	if errors.Is(err, database.ErrNotFound) {
+		p.RenderError(c, http.StatusNotFound, "That day does not exist.")
+		return record, false
+	} else if err != nil {
+		log.Print(err)
+		p.RenderError(c, http.StatusInternalServerError, "The day could not be loaded.")
+		return record, false
+	}
+	return record, true
+}
+
+func dayPath(id int64) string {
+	return "/days/" + strconv.FormatInt(id, 10)
+}
+
+func (p *Pages) EditDay(c *gin.Context) {
+	record, ok := p.loadDay(c)
+	if !ok {
+		return
+	}
+	p.render(c, http.StatusOK, "day_form", "Edit "+record.Time.Format(p.cfg.DateFormat), newDayFormData(dayPath(record.ID), record))
+}
+
+// UpdateDay saves the edit form, replacing every value of the day.
+func (p *Pages) UpdateDay(c *gin.Context) {
+	existing, ok := p.loadDay(c)
+	if !ok {
+		return
+	}
+
+	record, data, ok := p.parseDayForm(c)
+	if !ok {
+		data.Action = dayPath(existing.ID)
+		p.render(c, http.StatusBadRequest, "day_form", "Edit "+existing.Time.Format(p.cfg.DateFormat), data)
+		return
+	}
+
+	record.ID = existing.ID
+	err := p.store.UpdateDay(api.CurrentUser(c).ID, record)
+	if errors.Is(err, database.ErrDuplicateDay) {
+		data.Action = dayPath(existing.ID)
+		data.Errors = map[string]string{"date": "already has a record"}
+		p.render(c, http.StatusBadRequest, "day_form", "Edit "+existing.Time.Format(p.cfg.DateFormat), data)
+		return
+	} else if errors.Is(err, database.ErrMeasuredWeight) {
+		data.Action = dayPath(existing.ID)
+		data.Errors = map[string]string{"weight": measuredWeightError}
+		p.render(c, http.StatusConflict, "day_form", "Edit "+existing.Time.Format(p.cfg.DateFormat), data)
+		return
+	} else if err != nil {
+		log.Print(err)
+		p.RenderError(c, http.StatusInternalServerError, "The day could not be saved.")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/table")
+}
+
+type confirmDeleteData struct {
+	Action string
+	Date   string
+}
+
+func (p *Pages) ConfirmDeleteDay(c *gin.Context) {
+	record, ok := p.loadDay(c)
+	if !ok {
+		return
+	}
+	p.render(c, http.StatusOK, "confirm_delete", "Delete day",
+		confirmDeleteData{Action: dayPath(record.ID) + "/delete", Date: record.Time.Format(p.cfg.DateFormat)})
+}
+
+func (p *Pages) DeleteDay(c *gin.Context) {
+	record, ok := p.loadDay(c)
+	if !ok {
+		return
+	}
+
+	if err := p.store.DeleteDay(api.CurrentUser(c).ID, record.ID); err != nil {
+		log.Print(err)
+		p.RenderError(c, http.StatusInternalServerError, "The day could not be deleted.")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/table")
+}
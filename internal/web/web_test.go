@@ -0,0 +1,158 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.ebain.es/healthAndFitnessTracker/internal/api"
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
+	"git.ebain.es/healthAndFitnessTracker/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter(t *testing.T) (*gin.Engine, *database.Store) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := database.Open(database.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateUser("alice", "correct horse", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := NewPages(config.Default(), store, func(userID int64) (string, error) {
+		return "<table></table>", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.GET(LoginPath, pages.LoginForm)
+	r.POST(LoginPath, pages.Login)
+	session := r.Group("/", api.RequireSession(store, LoginPath))
+	session.GET("/table", pages.Table)
+	session.GET("/log", pages.NewDay)
+	session.POST("/days", pages.CreateDay)
+	return r, store
+}
+
+func postForm(r http.Handler, path string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func findCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestLoginAndSubmitDay(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	req := httptest.NewRequest(http.MethodGet, LoginPath, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	loginCookie := findCookie(w, loginCSRFCookie)
+	if w.Code != http.StatusOK || loginCookie == nil || !strings.Contains(w.Body.String(), loginCookie.Value) {
+		t.Fatalf("GET %v returned %v without the login CSRF token", LoginPath, w.Code)
+	}
+
+	login := url.Values{"username": {"alice"}, "password": {"correct horse"}, "next": {"/log"}}
+	w = postForm(r, LoginPath, login, loginCookie)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Login without a CSRF token returned %v not 403", w.Code)
+	}
+
+	login.Set(api.CSRFField, loginCookie.Value)
+	w = postForm(r, LoginPath, login, nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Login without the CSRF cookie returned %v not 403", w.Code)
+	}
+
+	login.Set("password", "wrong")
+	w = postForm(r, LoginPath, login, loginCookie)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Login with a wrong password returned %v not 401", w.Code)
+	}
+
+	login.Set("password", "correct horse")
+	w = postForm(r, LoginPath, login, loginCookie)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/log" {
+		t.Fatalf("Login returned %v redirecting to %q", w.Code, w.Header().Get("Location"))
+	}
+	cookie := findCookie(w, api.SessionCookie)
+
+	user, session, err := store.GetSession(cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"date": {"2021-03-01"}, "weight": {"80.26"}, "calories": {"2500"}}
+	w = postForm(r, "/days", form, cookie)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Submitting without a CSRF token returned %v not 403", w.Code)
+	}
+
+	form.Set(api.CSRFField, session.CSRFToken)
+	w = postForm(r, "/days", form, cookie)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Submitting the day returned %v", w.Code)
+	}
+
+	records, err := store.ListDays(user.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Weight.Float64 != 80.3 || records[0].Calories.Float64 != 2500 {
+		t.Errorf("Submitting the day stored %+v", records)
+	}
+
+	form.Set("weight", "heavy")
+	w = postForm(r, "/days", form, cookie)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Weight must be a number") {
+		t.Errorf("Submitting an invalid weight returned %v", w.Code)
+	}
+}
+
+func TestPagesRequireSession(t *testing.T) {
+	r, store := newTestRouter(t)
+	defer store.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/table", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), LoginPath) {
+		t.Errorf("GET /table without a session returned %v redirecting to %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	tests := map[string]string{
+		"/log":                "/log",
+		"":                    "/table",
+		"https://example.com": "/table",
+		"//example.com":       "/table",
+		"/\\example.com":      "/table",
+	}
+	for next, want := range tests {
+		if got := safeRedirect(next); got != want {
+			t.Errorf("safeRedirect(%q) returned %q not %q", next, got, want)
+		}
+	}
+}
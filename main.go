@@ -2,306 +2,143 @@ package main
 
 import (
 	"database/sql"
+	"flag"
+	"git.ebain.es/healthAndFitnessTracker/internal/analysis"
 	api "git.ebain.es/healthAndFitnessTracker/internal/api"
+	"git.ebain.es/healthAndFitnessTracker/internal/config"
 	database "git.ebain.es/healthAndFitnessTracker/internal/database"
-	regression "git.ebain.es/healthAndFitnessTracker/internal/regression"
+	"git.ebain.es/healthAndFitnessTracker/internal/web"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/wcharczuk/go-chart"
 )
 
-const dateFormat = "02/01/2006"
-const sqliteConnString = "file:/home/ebaines/Downloads/fitness.db"
-
-
 func main() {
-	r := gin.Default()
-
-	r.GET("/table", genTable)
-
-	apiRouter := r.Group("/api")
-	{
-		apiRouter.GET("/weight/:id", api.GetWeight)
-		apiRouter.POST("/weight", api.AddWeight)
-		apiRouter.PUT("/weight/:id", api.AddWeight)
-		apiRouter.DELETE("/weight/:id", api.DeleteWeight)
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 	}
-	_ = r.Run()
-}
 
-func genTable(c *gin.Context) {
-	table := processDatabase()
-	c.Data(http.StatusOK,
-		"text/html; charset=utf-8", []byte(table))
-}
-
-func processDatabase() string {
-	db, err := sql.Open("sqlite3", sqliteConnString)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-
-	var dateRange = 1000
-	records := database.GetFinalRows(db, dateRange)
-
-	var dates = make([]time.Time, 0, 1000)
-	var weightDates = make([]time.Time, 0, 1000)
-	var calorieDates = make([]time.Time, 0, 1000)
-	var weights, calories []float64
-
-	// Only plot the datapoint if the weight/calorie isn't NULL in the table.
-	for _, record := range records {
-		dates = append(dates, record.Time)
-		if record.Weight.Valid {
-			weightDates = append(weightDates, record.Time)
-			weights = append(weights, record.Weight.Float64)
-		}
-		if record.Calories.Valid {
-			calorieDates = append(calorieDates, record.Time)
-			calories = append(calories, record.Calories.Float64)
-		}
-	}
 
-	//Calculate smoothed line for weights.
-	_, loessWeights := regression.CoordsToArrays(loessSmoothTimeSeries(dates, weightDates, weights, 0.2))
-
-	//Calculate smoothed line for calories
-	_, loessCalories := regression.CoordsToArrays(loessSmoothTimeSeries(dates, calorieDates, calories, 0.2))
-
-	//Calculate weight change per day and smooth.
-	dayWeightDelta := calculateDayDifferences(weights, 1)
-	_, loessDayWeightDelta := regression.CoordsToArrays(loessSmoothTimeSeries(dates, weightDates, dayWeightDelta, 0.4))
-
-	//Calculate calories consumed per kg of bodyweight each day and smooth.
-	//var caloriesPerKg []float64
-	//for i, weight := range weights {
-	//	caloriesPerKg = append(caloriesPerKg, calories[i]/weight)
-	//}
-	//_, loessCaloriesPerKg := regression.CoordsToArrays(loessSmoothTimeSeries(dates, calorieDates, caloriesPerKg, 0.3))
-
-	//Calculate current TDEE
-	calorieSlidingAverage := slidingAvgs(loessCalories, 14)
-	weightDeltaSlidingAverage := slidingAvgs(loessDayWeightDelta, 14)
-	var tdee []float64
-	for i, calorieAverage := range calorieSlidingAverage {
-		//tdee = append(tdee, calorieAverage-weightDeltaSlidingAverage[i]*7000)
-		tdee = append(tdee, calculateTDEE(calorieAverage, weightDeltaSlidingAverage[i]))
-	}
-
-	differences := calculateDayDifferences(loessWeights, 7)
-	bigDifferences := calculateDayDifferences(loessWeights, 28)
-	differencesCals := calculateDayDifferences(loessCalories, 7)
-
-	var t HtmlTable
-	//t.setHeaders([]string{"Date", "Calories", "Day's Weight", "Rolling Weight", "Rolling Smoothed Calories", "1 Day ΔM", "7 Day ΔM", "28 Day ΔM", "7 Day ΔKCal", "TDEE"})
-	t.setHeaders([]string{"Date", "Rolling Weight", "Rolling Smoothed Calories", "1 Day ΔM", "7 Day ΔM", "28 Day ΔM", "7 Day ΔKCal", "TDEE"})
-	for i := 0; i < len(differences); i++ {
-		t.addRow([]string{
-			dates[i].Format(dateFormat),
-			//strconv.FormatFloat(calories[i], 'f', 2, 64),
-			//strconv.FormatFloat(weights[i], 'f', 2, 64),
-			strconv.FormatFloat(loessWeights[i], 'f', 2, 64),
-			strconv.FormatFloat(loessCalories[i], 'f', 2, 64),
-			strconv.FormatFloat(loessDayWeightDelta[i], 'f', 2, 64),
-			strconv.FormatFloat(differences[i], 'f', 2, 64),
-			strconv.FormatFloat(bigDifferences[i], 'f', 2, 64),
-			strconv.FormatFloat(differencesCals[i], 'f', 2, 64),
-			strconv.FormatFloat(bufferStart(tdee, 27, 0.0, i), 'f', 2, 64),
-		})
-	}
-	renderedTable := t.render()
-
-	graph := chart.Chart{
-		XAxis: chart.XAxis{
-			Name: "Date",
-		},
-		YAxis: chart.YAxis{
-			Name: "Weight /kg",
-		},
-		YAxisSecondary: chart.YAxis{
-			Name: "Calories /kcal",
-		},
-		Series: []chart.Series{
-			chart.TimeSeries{
-				Name:    "Smoothed Daily Weight",
-				XValues: weightDates,
-				YValues: loessWeights,
-			},
-			chart.TimeSeries{
-				Name:    "Smoothed Daily Calories",
-				YAxis:   chart.YAxisSecondary,
-				XValues: calorieDates[7:],
-				YValues: loessCalories[7:],
-			},
-		},
-	}
-	graph.Elements = []chart.Renderable{
-		chart.LegendThin(&graph),
+	store, err := openStore(cfg)
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer store.Close()
 
-	//graph2 := chart.Chart{
-	//	XAxis: chart.XAxis{
-	//		Name: "Date",
-	//	},
-	//	YAxis: chart.YAxis{
-	//		Name: "Weight Gain /kg/day",
-	//	},
-	//	YAxisSecondary: chart.YAxis{
-	//		Name: "Calories per Kg /kcal",
-	//	},
-	//	Series: []chart.Series{
-	//		chart.TimeSeries{
-	//			Name:    "Smoothed Weight Gain",
-	//			XValues: weightDates,
-	//			YValues: loessDayWeightDelta,
-	//		},
-	//		chart.TimeSeries{
-	//			Name:    "Smoothed Daily Calories",
-	//			YAxis:   chart.YAxisSecondary,
-	//			XValues: calorieDates,
-	//			YValues: loessCaloriesPerKg,
-	//		},
-	//	},
-	//}
-
-	//graph2.Elements = []chart.Renderable{
-	//	chart.LegendThin(&graph2),
-	//}
-
-	graph3 := chart.Chart{
-		XAxis: chart.XAxis{
-			Name: "Date",
-		},
-		YAxis: chart.YAxis{
-			Name: "Calories /kcal",
-		},
-		YAxisSecondary: chart.YAxis{
-			Name: "Weight /kg",
-		},
-		Series: []chart.Series{
-			//chart.TimeSeries{
-			//	Name:    "TDEE per KG",
-			//	XValues: dates[28:],
-			//	YValues: tdeePerKg,
-			//},
-			chart.TimeSeries{
-				Name:    "TDEE",
-				XValues: weightDates[28:],
-				YValues: tdee,
-			},
-			chart.TimeSeries{
-				Name:    "Weight /kg",
-				YAxis:   chart.YAxisSecondary,
-				XValues: weightDates,
-				YValues: loessWeights,
-			},
-		},
-	}
+	r := gin.Default()
 
-	graph3.Elements = []chart.Renderable{
-		chart.LegendThin(&graph3),
+	pages, err := web.NewPages(cfg, store, func(userID int64) (string, error) {
+		return processDatabase(cfg, store, userID)
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	//buffer := bytes.NewBuffer([]byte{})
-	//err = graph.Render(chart.PNG, buffer)
-	//buffer2 := bytes.NewBuffer([]byte{})
-	//err = graph2.Render(chart.PNG, buffer2)
-	//buffer3 := bytes.NewBuffer([]byte{})
-	//err = graph3.Render(chart.PNG, buffer3)
-	//
-	//f, err := os.Create("./output.png")
-	//err = ioutil.WriteFile("output.png", buffer.Bytes(), 0644)
-	//f, err := os.Create("./output.png")
-	//err = ioutil.WriteFile("output2.png", buffer2.Bytes(), 0644)
-	//err = ioutil.WriteFile("output3.png", buffer3.Bytes(), 0644)
-
-	return renderedTable
-}
-
-func slidingAvgs(dayValues []float64, width int) []float64 {
-	var weekAvg float64
-	var avgSlice = make([]float64, 0)
+	r.GET("/", func(c *gin.Context) { c.Redirect(http.StatusSeeOther, "/table") })
+	r.GET(web.LoginPath, pages.LoginForm)
+	r.POST(web.LoginPath, pages.Login)
 
-	for i := width - 1; i < len(dayValues); i++ {
-		weekAvg = 0
-		for _, dayValue := range dayValues[i-(width-1) : i+1] {
-			weekAvg += dayValue
-		}
-
-		weekAvg = weekAvg / float64(width)
-
-		avgSlice = append(avgSlice, weekAvg)
-	}
-	return avgSlice
+	pageRouter := r.Group("/", api.RequireSession(store, web.LoginPath))
+	{
+		pageRouter.POST("/logout", pages.Logout)
+		pageRouter.GET("/table", pages.Table)
+		pageRouter.GET("/log", pages.NewDay)
+		pageRouter.POST("/days", pages.CreateDay)
+		pageRouter.GET("/days/:id/edit", pages.EditDay)
+		pageRouter.POST("/days/:id", pages.UpdateDay)
+		pageRouter.GET("/days/:id/delete", pages.ConfirmDeleteDay)
+		pageRouter.POST("/days/:id/delete", pages.DeleteDay)
+		pageRouter.GET("/charts/weight.png", renderChart(cfg, store, weightChart, chart.PNG, "image/png"))
+		pageRouter.GET("/charts/weight.svg", renderChart(cfg, store, weightChart, chart.SVG, "image/svg+xml"))
+		pageRouter.GET("/charts/tdee.png", renderChart(cfg, store, tdeeChart, chart.PNG, "image/png"))
+		pageRouter.GET("/charts/tdee.svg", renderChart(cfg, store, tdeeChart, chart.SVG, "image/svg+xml"))
+	}
+
+	requireUser := api.RequireUser(store)
+
+	days := api.NewHandler(cfg, store)
+	apiRouter := r.Group("/api", requireUser)
+	{
+		apiRouter.GET("/weight", days.ListWeights)
+		apiRouter.GET("/weight/:id", days.GetWeight)
+		apiRouter.POST("/weight", days.AddWeight)
+		apiRouter.PUT("/weight/:id", days.AddWeight)
+		apiRouter.PATCH("/weight/:id", days.PatchWeight)
+		apiRouter.DELETE("/weight/:id", days.DeleteWeight)
+		apiRouter.PUT("/days/:date", days.PutDay)
+		apiRouter.GET("/analytics", days.Analytics)
+		apiRouter.GET("/export/:file", days.Export)
+		apiRouter.POST("/import", days.Import)
+		apiRouter.POST("/measurements", days.AddMeasurement)
+		apiRouter.GET("/weight/:id/measurements", days.ListMeasurements)
+		apiRouter.DELETE("/measurements/:id", days.DeleteMeasurement)
+	}
+	_ = r.Run(cfg.ListenAddr)
 }
 
-func calculateDifferences(values []float64) []float64 {
-	var diffSlice = make([]float64, 0)
-
-	for i := 1; i < len(values); i++ {
-		diffSlice = append(diffSlice, values[i]-values[i-1])
+// openStore opens the configured database with the configured weight aggregation.
+func openStore(cfg *config.Config) (*database.Store, error) {
+	store, err := database.Open(cfg.DatabasePath)
+	if err != nil {
+		return nil, err
 	}
-	return diffSlice
+	store.SetWeightAggregation(cfg.Aggregation())
+	return store, nil
 }
 
-func calculateDayDifferences(values []float64, days int) []float64 {
-	var diffSlice = make([]float64, 0)
-
-	for i := 0; i < len(values); i++ {
-		if i-days < 0 {
-			diffSlice = append(diffSlice, 0)
-		} else {
-			diffSlice = append(diffSlice, values[i]-values[i-days])
-		}
+func processDatabase(cfg *config.Config, store *database.Store, userID int64) (string, error) {
+	report, err := analysis.ForUser(store, userID, analysis.OptionsFromConfig(cfg), time.Time{}, time.Time{})
+	if err != nil {
+		return "", err
 	}
-	return diffSlice
-}
 
-func calculateTDEE(avgDayCalories float64, avgDayWeightDiff float64) float64 {
-	// Approx 3500kcal = 450g fat
-	fatCaloriesDiff := (avgDayWeightDiff * 3500) / 0.450
-	tdee := avgDayCalories - fatCaloriesDiff
-	return tdee
+	return renderTable(cfg, report), nil
 }
 
-func loessSmoothTimeSeries(datesToEstimate []time.Time, dates []time.Time, yPoints []float64, bandwidth float64) []regression.Coord {
-	// Calculate smoothed line for weights.
-	var xPointsToEstimate = make([]float64, 0, len(datesToEstimate))
-	for xPoint, _ := range datesToEstimate {
-		xPointsToEstimate = append(xPointsToEstimate, float64(xPoint))
-	}
-	var xPoints = make([]float64, 0, len(dates))
-	for xPoint, _ := range dates {
-		xPoints = append(xPoints, float64(xPoint))
-	}
-
-	var coordinates []regression.Coord
-	for i := 0; i < len(xPoints); i++ {
-		coordinates = append(coordinates, regression.Coord{
-			X: xPoints[i],
-			Y: yPoints[i],
+func renderTable(cfg *config.Config, report *analysis.Report) string {
+	var t HtmlTable
+	t.setHeaders([]string{"Date", "Rolling Weight", "Rolling Smoothed Calories", "1 Day ΔM", "7 Day ΔM", "28 Day ΔM", "7 Day ΔKCal", "TDEE", ""})
+	for i := 0; i < report.Len(); i++ {
+		t.addRow([]string{
+			report.Dates[i].Format(cfg.DateFormat),
+			formatCell(report.Weight[i]),
+			formatCell(report.Calories[i]),
+			formatCell(report.WeightChange[i]),
+			formatCell(report.WeightChange7[i]),
+			formatCell(report.WeightChange28[i]),
+			formatCell(report.CalorieChange7[i]),
+			formatCell(report.TDEE[i]),
+			rowActions(report.Records[i].ID),
 		})
 	}
+	return t.render()
+}
 
-	loessCoords, err := regression.CalcLOESS(xPointsToEstimate, coordinates, bandwidth)
-	if err != nil {
-		log.Fatal(err)
+// formatCell formats a value for the table, leaving the cell empty when there is no value.
+func formatCell(value sql.NullFloat64) string {
+	if !value.Valid {
+		return ""
 	}
-
-	return loessCoords
-
+	return strconv.FormatFloat(value.Float64, 'f', 2, 64)
 }
 
-func bufferStart(series []float64, start int, buffer float64, i int) float64 {
-	if i < start {
-		return buffer
-	} else {
-		return series[i-start]
-	}
+// rowActions links a table row to the forms for editing and deleting its day.
+func rowActions(id int64) string {
+	path := "/days/" + strconv.FormatInt(id, 10)
+	return "<a href=\"" + path + "/edit\">Edit</a> <a href=\"" + path + "/delete\">Delete</a>"
 }